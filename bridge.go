@@ -0,0 +1,279 @@
+package zerosvc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MetadataBridgeHops is transport metadata (MQTTv5 user property) with comma-separated IDs
+// of bridges message was forwarded by. Event itself is never modified, so signatures stay valid
+const MetadataBridgeHops = "zerosvc-bridge-hops"
+
+const (
+	DefaultBridgeMaxHops = 4
+	// DefaultBridgeEchoTTL is how long forwarded messages are remembered to drop their echo on transports
+	// that can't carry MetadataBridgeHops
+	DefaultBridgeEchoTTL = time.Second * 30
+)
+
+// BridgeRoute is a topic filter forwarded in one direction
+type BridgeRoute struct {
+	// Filter is full topic filter (including event root) subscribed on the source transport
+	Filter string
+	// StripPrefix is removed from the topic and AddPrefix is prepended to it before publishing to destination,
+	// for example to bridge between different event roots
+	StripPrefix string
+	AddPrefix   string
+}
+
+// rewrite returns topic on destination
+func (r *BridgeRoute) rewrite(topic string) string {
+	return r.AddPrefix + strings.TrimPrefix(topic, r.StripPrefix)
+}
+
+type BridgeConfig struct {
+	// ID of the bridge stamped into MetadataBridgeHops; random if empty. Bridges between same brokers need distinct IDs
+	ID string
+	// A and B are connected transports to bridge
+	A Transport
+	B Transport
+	// AtoB are routes forwarded from A to B, BtoA from B to A
+	AtoB []BridgeRoute
+	BtoA []BridgeRoute
+	// MaxHops drops messages that already passed thru that many bridges, DefaultBridgeMaxHops if 0
+	MaxHops int
+	// EchoTTL, DefaultBridgeEchoTTL if 0
+	EchoTTL time.Duration
+	// EchoCacheSize is max number of forwarded messages remembered, 10000 if 0
+	EchoCacheSize int
+	// AllowWithoutMetadata lets bridge start when neither transport carries metadata (see MetadataCarrier),
+	// like two MQTTv3 ones. Echo detection only stops messages bouncing between the two brokers of one bridge;
+	// cycle of three or more such bridges loops forever, as hop count can't be carried
+	AllowWithoutMetadata bool
+	Logger               *zap.SugaredLogger
+}
+
+// Bridge forwards messages between two transports. Payload is forwarded as-is and ReplyTo inside events
+// is not rewritten, as that would break the signature. Retain flag is forwarded as received, so retained
+// messages present when bridge subscribes are mirrored as retained while live updates are not,
+// same as any other MQTT subscriber sees them.
+//
+// Loops are prevented by MetadataBridgeHops; transports without metadata support (MQTTv3) fall back
+// to dropping messages identical to ones the bridge just published to that side. That does not stop
+// loops going thru more than two brokers, so every broker in such cycle has to carry metadata
+type Bridge struct {
+	cfg       BridgeConfig
+	echo      *echoCache
+	forwarded atomic.Uint64
+	dropped   atomic.Uint64
+	stopped   atomic.Bool
+	l         *zap.SugaredLogger
+}
+
+func NewBridge(cfg BridgeConfig) (*Bridge, error) {
+	if cfg.A == nil || cfg.B == nil {
+		return nil, fmt.Errorf("both transports required")
+	}
+	if len(cfg.AtoB) == 0 && len(cfg.BtoA) == 0 {
+		return nil, fmt.Errorf("at least one route required")
+	}
+	if !carriesMetadata(cfg.A) && !carriesMetadata(cfg.B) && !cfg.AllowWithoutMetadata {
+		return nil, fmt.Errorf("neither transport carries metadata needed for loop prevention, see AllowWithoutMetadata")
+	}
+	if len(cfg.ID) == 0 {
+		cfg.ID = mapBytesToTopicTitle(rngBlob(6))
+	}
+	if strings.Contains(cfg.ID, ",") {
+		return nil, fmt.Errorf("bridge ID can't contain [,]")
+	}
+	if cfg.MaxHops <= 0 {
+		cfg.MaxHops = DefaultBridgeMaxHops
+	}
+	if cfg.EchoTTL <= 0 {
+		cfg.EchoTTL = DefaultBridgeEchoTTL
+	}
+	if cfg.EchoCacheSize <= 0 {
+		cfg.EchoCacheSize = 10000
+	}
+	b := &Bridge{
+		cfg:  cfg,
+		echo: &echoCache{size: cfg.EchoCacheSize, ttl: cfg.EchoTTL, keys: map[string]*echoEntry{}},
+		l:    cfg.Logger,
+	}
+	if b.l == nil {
+		b.l = zap.NewNop().Sugar()
+	}
+	return b, nil
+}
+
+// Start subscribes to all routes
+func (b *Bridge) Start() error {
+	for _, r := range b.cfg.AtoB {
+		if err := b.route(b.cfg.A, b.cfg.B, "b", r); err != nil {
+			return err
+		}
+	}
+	for _, r := range b.cfg.BtoA {
+		if err := b.route(b.cfg.B, b.cfg.A, "a", r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops forwarding. Transports are left connected, as they are owned by caller
+func (b *Bridge) Stop() {
+	b.stopped.Store(true)
+}
+
+// Forwarded returns number of forwarded messages
+func (b *Bridge) Forwarded() uint64 {
+	return b.forwarded.Load()
+}
+
+// Dropped returns number of messages dropped by loop prevention
+func (b *Bridge) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *Bridge) route(src Transport, dst Transport, dstSide string, r BridgeRoute) error {
+	messages := make(chan *Message, 256)
+	if err := src.Subscribe(r.Filter, messages); err != nil {
+		return fmt.Errorf("bridge: error subscribing to [%s]: %w", r.Filter, err)
+	}
+	srcSide := "a"
+	if dstSide == "a" {
+		srcSide = "b"
+	}
+	go func() {
+		for m := range messages {
+			if b.stopped.Load() {
+				// transport can't unsubscribe, keep draining
+				continue
+			}
+			b.forward(m, dst, srcSide, dstSide, &r)
+		}
+	}()
+	return nil
+}
+
+func carriesMetadata(tr Transport) bool {
+	m, ok := tr.(MetadataCarrier)
+	return ok && m.CarriesMetadata()
+}
+
+// echoKey identifies message on one side of the bridge
+func echoKey(side string, topic string, payload []byte) string {
+	h := sha256.Sum256(payload)
+	return side + "|" + topic + "|" + hex.EncodeToString(h[:16])
+}
+
+func (b *Bridge) forward(m *Message, dst Transport, srcSide string, dstSide string, r *BridgeRoute) {
+	if b.echo.consume(echoKey(srcSide, m.Topic, m.Payload)) {
+		b.dropped.Add(1)
+		b.l.Debugf("bridge %s: dropping [%s], echo of forwarded message", b.cfg.ID, m.Topic)
+		return
+	}
+	var hops []string
+	if h := m.Metadata[MetadataBridgeHops]; len(h) > 0 {
+		hops = strings.Split(h, ",")
+	}
+	for _, id := range hops {
+		if id == b.cfg.ID {
+			b.dropped.Add(1)
+			b.l.Debugf("bridge %s: dropping [%s], already forwarded by this bridge", b.cfg.ID, m.Topic)
+			return
+		}
+	}
+	if len(hops) >= b.cfg.MaxHops {
+		b.dropped.Add(1)
+		b.l.Warnf("bridge %s: dropping [%s] after %d hops", b.cfg.ID, m.Topic, len(hops))
+		return
+	}
+	out := Message{
+		Topic:           r.rewrite(m.Topic),
+		ResponseTopic:   m.ResponseTopic,
+		CorrelationData: m.CorrelationData,
+		ContentType:     m.ContentType,
+		Metadata:        make(map[string]string, len(m.Metadata)+1),
+		Payload:         m.Payload,
+		Retain:          m.Retain,
+	}
+	for k, v := range m.Metadata {
+		out.Metadata[k] = v
+	}
+	out.Metadata[MetadataBridgeHops] = strings.Join(append(hops, b.cfg.ID), ",")
+	// remember what we publish, so it is not sent back if it comes in from destination
+	b.echo.add(echoKey(dstSide, out.Topic, out.Payload))
+	if err := dst.Publish(out); err != nil {
+		b.l.Errorf("bridge %s: error forwarding [%s] to [%s]: %s", b.cfg.ID, m.Topic, out.Topic, err)
+		return
+	}
+	b.forwarded.Add(1)
+}
+
+// echoCache remembers messages bridge published. Each publish is dropped once when it comes back, so later identical
+// message (like another retained clear of the same topic) is still forwarded
+type echoCache struct {
+	size int
+	ttl  time.Duration
+	keys map[string]*echoEntry
+	sync.Mutex
+}
+
+type echoEntry struct {
+	count int
+	ts    time.Time
+}
+
+func (c *echoCache) add(key string) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if e, ok := c.keys[key]; ok {
+		e.count++
+		e.ts = now
+		return
+	}
+	if len(c.keys) >= c.size {
+		for k, e := range c.keys {
+			if now.Sub(e.ts) > c.ttl {
+				delete(c.keys, k)
+			}
+		}
+		// still full, drop arbitrary entries; worst case is one echo forwarded back and stopped by hop metadata
+		for k := range c.keys {
+			if len(c.keys) < c.size {
+				break
+			}
+			delete(c.keys, k)
+		}
+	}
+	c.keys[key] = &echoEntry{count: 1, ts: now}
+}
+
+// consume returns true and decrements the key if it was added within ttl
+func (c *echoCache) consume(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.keys[key]
+	if !ok {
+		return false
+	}
+	if time.Since(e.ts) > c.ttl {
+		delete(c.keys, key)
+		return false
+	}
+	e.count--
+	if e.count <= 0 {
+		delete(c.keys, key)
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package zerosvc
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noMetadataTransport drops metadata on publish, like MQTTv3 does
+type noMetadataTransport struct {
+	*TransportMemory
+}
+
+func (t noMetadataTransport) CarriesMetadata() bool {
+	return false
+}
+
+func (t noMetadataTransport) Publish(m Message) error {
+	m.Metadata = nil
+	return t.TransportMemory.Publish(m)
+}
+
+func bridgeTestTransport(t *testing.T, broker *MemoryBroker) *TransportMemory {
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	require.NoError(t, tr.Connect(Hooks{}, "bridge/"+t.Name()))
+	return tr
+}
+
+func TestBridge(t *testing.T) {
+	siteA := NewMemoryBroker()
+	siteB := NewMemoryBroker()
+	newNode := func(broker *MemoryBroker, name string, root string) *Node {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: root})
+		require.NoError(t, err)
+		return n
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := NewSignerEd25519(priv)
+	require.NoError(t, err)
+	verifier, err := SignerEd25519FromPub(pub)
+	require.NoError(t, err)
+
+	ovenA := newNode(siteA, "oven-a", "site-a")
+	ovenA.Lock()
+	ovenA.Signer = signer
+	ovenA.Unlock()
+	// retained before bridge starts, should be mirrored as retained
+	ev := ovenA.NewEvent()
+	ev.Body = []byte("180")
+	ev.SetRetain(true)
+	require.NoError(t, ovenA.SendEvent("ovens/temp", ev))
+
+	bridge, err := NewBridge(BridgeConfig{
+		ID:   "ab",
+		A:    bridgeTestTransport(t, siteA),
+		B:    bridgeTestTransport(t, siteB),
+		AtoB: []BridgeRoute{{Filter: "site-a/ovens/#", StripPrefix: "site-a/", AddPrefix: "site-b/"}},
+		BtoA: []BridgeRoute{{Filter: "site-b/ovens/#", StripPrefix: "site-b/", AddPrefix: "site-a/"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, bridge.Start())
+	defer bridge.Stop()
+	require.Eventually(t, func() bool { return bridge.Dropped() == 1 }, time.Second, time.Millisecond*5,
+		"forwarded copy comes back thru B->A route and must not be re-sent to A")
+	retained, found := siteB.Retained("site-b/ovens/temp")
+	require.True(t, found, "retained event should be mirrored")
+	assert.Equal(t, "ab", retained.Metadata[MetadataBridgeHops])
+
+	watcherA := newNode(siteA, "watcher-a", "site-a")
+	watcherB := newNode(siteB, "watcher-b", "site-b")
+	watcherB.PubkeyRetriever = func(nodeName string, nodeUUID string) (Verifier, bool) {
+		return verifier, nodeName == "oven-a"
+	}
+	eventsA, err := watcherA.GetEventsCh("ovens/#")
+	require.NoError(t, err)
+	eventsB, err := watcherB.GetEventsCh("ovens/#")
+	require.NoError(t, err)
+	got := goneric.ChanToSliceNTimeout(eventsB, 1, time.Second)
+	require.Len(t, got, 1)
+	assert.Equal(t, "site-b/ovens/temp", got[0].Topic)
+	assert.Equal(t, "oven-a", got[0].NodeName)
+	assert.Equal(t, []byte("180"), got[0].Body)
+	assert.NotEmpty(t, got[0].Signature, "signature should survive the bridge and still verify")
+	// retained on A
+	assert.Len(t, goneric.ChanToSliceNTimeout(eventsA, 2, time.Millisecond*100), 1)
+
+	ovenB := newNode(siteB, "oven-b", "site-b")
+	require.NoError(t, ovenB.SendEvent("ovens/temp", ovenB.NewEvent()))
+	got = goneric.ChanToSliceNTimeout(eventsA, 1, time.Second)
+	require.Len(t, got, 1)
+	assert.Equal(t, "oven-b", got[0].NodeName)
+	assert.Equal(t, "site-a/ovens/temp", got[0].Topic)
+	assert.Len(t, goneric.ChanToSliceNTimeout(eventsB, 2, time.Millisecond*100), 1, "B should see its own event once")
+	assert.Equal(t, uint64(2), bridge.Forwarded())
+	assert.Equal(t, uint64(2), bridge.Dropped())
+}
+
+func TestBridgeWithoutMetadata(t *testing.T) {
+	siteA := NewMemoryBroker()
+	siteB := NewMemoryBroker()
+	trA := noMetadataTransport{bridgeTestTransport(t, siteA)}
+	trB := noMetadataTransport{bridgeTestTransport(t, siteB)}
+	routes := []BridgeRoute{{Filter: "test/#"}}
+	_, err := NewBridge(BridgeConfig{A: trA, B: trB, AtoB: routes, BtoA: routes})
+	assert.ErrorContains(t, err, "metadata", "should refuse to start without explicit opt-in")
+	bridge, err := NewBridge(BridgeConfig{A: trA, B: trB, AtoB: routes, BtoA: routes, AllowWithoutMetadata: true})
+	require.NoError(t, err)
+	require.NoError(t, bridge.Start())
+	onB := make(chan *Message, 16)
+	require.NoError(t, bridgeTestTransport(t, siteB).Subscribe("test/#", onB))
+	onA := make(chan *Message, 16)
+	require.NoError(t, bridgeTestTransport(t, siteA).Subscribe("test/#", onA))
+
+	publisher := bridgeTestTransport(t, siteA)
+	for i := 0; i < 2; i++ {
+		// identical messages, second one still has to pass
+		require.NoError(t, publisher.Publish(Message{Topic: "test/x", Payload: []byte("x")}))
+	}
+	assert.Len(t, goneric.ChanToSliceNTimeout(onB, 3, time.Millisecond*200), 2)
+	assert.Len(t, goneric.ChanToSliceNTimeout(onA, 3, time.Millisecond*100), 2, "echo should not be forwarded back")
+	assert.Equal(t, uint64(2), bridge.Forwarded())
+}
+
+func TestBridgeMaxHops(t *testing.T) {
+	brokers := []*MemoryBroker{NewMemoryBroker(), NewMemoryBroker(), NewMemoryBroker()}
+	// ring of bridges a->b->c->a, only hop limit and own ID stop the loop
+	var bridges []*Bridge
+	for i := range brokers {
+		b, err := NewBridge(BridgeConfig{
+			A:       bridgeTestTransport(t, brokers[i]),
+			B:       bridgeTestTransport(t, brokers[(i+1)%3]),
+			AtoB:    []BridgeRoute{{Filter: "test/#"}},
+			MaxHops: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, b.Start())
+		bridges = append(bridges, b)
+	}
+	require.NoError(t, bridgeTestTransport(t, brokers[0]).Publish(Message{Topic: "test/x", Payload: []byte("x")}))
+	require.Eventually(t, func() bool { return bridges[2].Dropped() == 1 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, uint64(1), bridges[0].Forwarded())
+	assert.Equal(t, uint64(1), bridges[1].Forwarded())
+	assert.Equal(t, uint64(0), bridges[2].Forwarded())
+}
@@ -0,0 +1,221 @@
+// Package capture records raw zerosvc traffic to a file and replays it back, for incident analysis and testing.
+//
+// Capture file is a header followed by CBOR encoded Records
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// FileVersion is version of the capture format written by Writer
+const FileVersion = 1
+
+var fileMagic = []byte("ZSCAP")
+
+// Record is single captured message
+type Record struct {
+	// TS is time the message was received
+	TS time.Time `cbor:"ts"`
+	// Message as received from transport, with its properties
+	Message zerosvc.Message `cbor:"m"`
+	// Node and NodeUUID of the sender, if the message was decoded at capture time
+	Node     string `cbor:"node,omitempty"`
+	NodeUUID string `cbor:"uuid,omitempty"`
+}
+
+// default CBOR time encoding only has second precision, not enough to replay with original timing
+var encMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+type Writer struct {
+	w   *bufio.Writer
+	enc *cbor.Encoder
+}
+
+// NewWriter writes file header and returns writer of records. Call Flush() when done
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(append(fileMagic, FileVersion)); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw, enc: encMode.NewEncoder(bw)}, nil
+}
+
+func (w *Writer) Write(rec Record) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+type Reader struct {
+	dec *cbor.Decoder
+}
+
+// NewReader checks file header and returns reader of records
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("error reading capture header: %w", err)
+	}
+	if !bytes.Equal(header[:len(fileMagic)], fileMagic) {
+		return nil, fmt.Errorf("not a capture file")
+	}
+	if header[len(fileMagic)] != FileVersion {
+		return nil, fmt.Errorf("unsupported capture version %d", header[len(fileMagic)])
+	}
+	return &Reader{dec: cbor.NewDecoder(br)}, nil
+}
+
+// Next returns next record, or io.EOF at the end of file. File cut in the middle of record
+// (like capture killed mid-write) returns io.ErrUnexpectedEOF
+func (r *Reader) Next() (rec Record, err error) {
+	err = r.dec.Decode(&rec)
+	return rec, err
+}
+
+type CaptureConfig struct {
+	Transport zerosvc.Transport
+	// Filters are full topics (including event root) to subscribe to
+	Filters []string
+	// Node, if set, is used to decode sender of the captured events, so replay can filter by it.
+	// Signatures are checked with node's keys, so events failing verification are recorded without sender
+	Node *zerosvc.Node
+	// Count of messages after which recording stops, 0 means until context is cancelled
+	Count int
+}
+
+// Capture records messages until context is cancelled or Count messages were recorded. Writer is flushed on return
+func Capture(ctx context.Context, cfg CaptureConfig, w *Writer) (count int, err error) {
+	defer func() {
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	if len(cfg.Filters) == 0 {
+		return 0, fmt.Errorf("at least one filter required")
+	}
+	messages := make(chan *zerosvc.Message, 1024)
+	for _, f := range cfg.Filters {
+		if err := cfg.Transport.Subscribe(f, messages); err != nil {
+			return 0, fmt.Errorf("error subscribing to [%s]: %w", f, err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return count, nil
+		case m := <-messages:
+			rec := Record{TS: time.Now(), Message: *m}
+			if cfg.Node != nil && len(m.Payload) > 0 {
+				if ev, err := (&zerosvc.Event{}).Deserialize(m.Payload, cfg.Node); err == nil {
+					rec.Node = ev.NodeName
+					rec.NodeUUID = ev.NodeUUID
+				}
+			}
+			if err := w.Write(rec); err != nil {
+				return count, err
+			}
+			count++
+			if cfg.Count > 0 && count >= cfg.Count {
+				return count, nil
+			}
+		}
+	}
+}
+
+type ReplayConfig struct {
+	Transport zerosvc.Transport
+	// Speed multiplier of original timing; 2 replays twice as fast. 0 sends everything without delays
+	Speed float64
+	// Nodes, if not empty, limits replay to events recorded with one of these sender names
+	Nodes []string
+	// From and To limit replay to records received in that range. Zero value means no limit
+	From time.Time
+	To   time.Time
+	// Retain keeps retain flag of the messages. Off by default, as retained messages that were received
+	// when capture subscribed would overwrite current state on the broker
+	Retain bool
+	// Rewrite, if set, changes topic of every replayed message, for example to replay into different event root
+	Rewrite func(topic string) string
+}
+
+// match returns true if record passes node and time filters
+func (cfg *ReplayConfig) match(rec *Record) bool {
+	if !cfg.From.IsZero() && rec.TS.Before(cfg.From) {
+		return false
+	}
+	if !cfg.To.IsZero() && rec.TS.After(cfg.To) {
+		return false
+	}
+	if len(cfg.Nodes) == 0 {
+		return true
+	}
+	for _, n := range cfg.Nodes {
+		if rec.Node == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Replay publishes records from reader, keeping original intervals between them divided by Speed.
+// It stops at the end of file or when context is cancelled
+func Replay(ctx context.Context, cfg ReplayConfig, r *Reader) (count int, err error) {
+	var firstTS, start time.Time
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if !cfg.match(&rec) {
+			continue
+		}
+		if cfg.Speed > 0 {
+			if firstTS.IsZero() {
+				firstTS = rec.TS
+				start = time.Now()
+			}
+			at := start.Add(time.Duration(float64(rec.TS.Sub(firstTS)) / cfg.Speed))
+			if wait := time.Until(at); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return count, nil
+				case <-t.C:
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return count, nil
+		default:
+		}
+		m := rec.Message
+		if !cfg.Retain {
+			m.Retain = false
+		}
+		m.Duplicate = false
+		if cfg.Rewrite != nil {
+			m.Topic = cfg.Rewrite(m.Topic)
+		}
+		if err := cfg.Transport.Publish(m); err != nil {
+			return count, fmt.Errorf("error publishing record %d: %w", count, err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,140 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	in := Record{
+		TS: ts,
+		Message: zerosvc.Message{
+			Topic:           "test/a",
+			ResponseTopic:   "test/reply",
+			CorrelationData: []byte{1, 2},
+			ContentType:     "application/cbor",
+			Metadata:        map[string]string{"k": "v"},
+			Payload:         []byte("payload"),
+			Retain:          true,
+		},
+		Node: "a",
+	}
+	require.NoError(t, w.Write(in))
+	require.NoError(t, w.Write(Record{TS: ts.Add(time.Millisecond), Message: zerosvc.Message{Topic: "test/b"}}))
+	require.NoError(t, w.Flush())
+	data := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := r.Next()
+	require.NoError(t, err)
+	assert.True(t, in.TS.Equal(out.TS), "receive time should keep nanosecond precision")
+	out.TS = in.TS
+	assert.Equal(t, in, out)
+	out, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "test/b", out.Message.Topic)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+
+	r, err = NewReader(bytes.NewReader(data[:len(data)-3]))
+	require.NoError(t, err)
+	_, err = r.Next()
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = NewReader(bytes.NewReader([]byte("not a capture")))
+	assert.Error(t, err)
+}
+
+func TestCaptureReplay(t *testing.T) {
+	broker := zerosvc.NewMemoryBroker()
+	newNode := func(name string, b *zerosvc.MemoryBroker) (*zerosvc.Node, zerosvc.Transport) {
+		tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: b})
+		require.NoError(t, err)
+		n, err := zerosvc.NewNode(zerosvc.Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n, tr
+	}
+	a, _ := newNode("a", broker)
+	b, _ := newNode("b", broker)
+	recorder, recorderTr := newNode("recorder", broker)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	done := make(chan int)
+	go func() {
+		count, err := Capture(context.Background(), CaptureConfig{
+			Transport: recorderTr,
+			Filters:   []string{"test/events/#"},
+			Node:      recorder,
+			Count:     3,
+		}, w)
+		assert.NoError(t, err)
+		done <- count
+	}()
+	time.Sleep(time.Millisecond * 20)
+	for i, n := range []*zerosvc.Node{a, b, a} {
+		ev := n.NewEvent()
+		ev.Body = []byte{byte(i)}
+		require.NoError(t, n.SendEvent("events/x", ev))
+		time.Sleep(time.Millisecond * 50)
+	}
+	select {
+	case count := <-done:
+		assert.Equal(t, 3, count)
+	case <-time.After(time.Second):
+		t.Fatal("capture did not stop after count")
+	}
+	data := buf.Bytes()
+
+	replay := func(cfg ReplayConfig) ([]zerosvc.Event, time.Duration) {
+		target, targetTr := newNode("target", zerosvc.NewMemoryBroker())
+		ch, err := target.GetEventsCh("events/#")
+		require.NoError(t, err)
+		r, err := NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		cfg.Transport = targetTr
+		start := time.Now()
+		_, err = Replay(context.Background(), cfg, r)
+		require.NoError(t, err)
+		took := time.Since(start)
+		return goneric.ChanToSliceNTimeout(ch, 3, time.Millisecond*200), took
+	}
+
+	events, took := replay(ReplayConfig{Speed: 1})
+	require.Len(t, events, 3)
+	assert.Equal(t, []string{"a", "b", "a"}, []string{events[0].NodeName, events[1].NodeName, events[2].NodeName})
+	assert.Equal(t, []byte{2}, events[2].Body)
+	assert.GreaterOrEqual(t, took, time.Millisecond*90, "original timing should be kept")
+
+	events, took = replay(ReplayConfig{Speed: 0, Nodes: []string{"b"}})
+	require.Len(t, events, 1)
+	assert.Equal(t, "b", events[0].NodeName)
+	assert.Less(t, took, time.Millisecond*50)
+
+	r, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	var recs []Record
+	for rec, err := r.Next(); err == nil; rec, err = r.Next() {
+		recs = append(recs, rec)
+	}
+	require.Len(t, recs, 3)
+	events, _ = replay(ReplayConfig{From: recs[1].TS, To: recs[1].TS})
+	require.Len(t, events, 1)
+	assert.Equal(t, []byte{1}, events[0].Body)
+}
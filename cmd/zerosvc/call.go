@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+type callOpts struct {
+	data    string
+	headers stringList
+	hexBody bool
+	timeout time.Duration
+	count   int
+	format  string
+	key     string
+	pubkey  string
+	verify  bool
+}
+
+func cmdCall(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: zerosvc call [flags] <path>
+
+Sends request to path (relative to event root) with generated reply topic and prints replies with their latency.
+Use -count 0 to collect all replies of broadcast request until timeout
+
+`)
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := callOpts{}
+	fs.StringVar(&o.data, "data", "", "request body; JSON string is sent as text, any other JSON as-is, invalid JSON as text")
+	fs.Var(&o.headers, "header", "request header as key=value, value is parsed as JSON if possible; can be repeated")
+	fs.BoolVar(&o.hexBody, "hex", false, "string body is hex-encoded binary")
+	fs.DurationVar(&o.timeout, "timeout", time.Second*5, "how long to wait for replies")
+	fs.IntVar(&o.count, "count", 1, "exit after receiving that many replies, 0 to wait for all until timeout")
+	fs.StringVar(&o.format, "format", "json", "output format, json or hex")
+	fs.StringVar(&o.key, "key", "", "private key file to sign request with; also used to decrypt replies encrypted to it")
+	fs.StringVar(&o.pubkey, "pubkey", "", "Ed25519 public key (file or base64) used to verify nodes that are not in discovery")
+	fs.BoolVar(&o.verify, "verify", false, "treat replies without verified signature as errors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("path required")
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return runCall(ctx, g, tr, fs.Arg(0), o, os.Stdout)
+}
+
+// callRequest builds request event from options
+func callRequest(node *zerosvc.Node, o callOpts) (zerosvc.Event, error) {
+	ev := node.NewEvent()
+	for _, h := range o.headers {
+		k, v, found := strings.Cut(h, "=")
+		if !found || len(k) == 0 {
+			return ev, fmt.Errorf("header [%s] should be key=value", h)
+		}
+		var parsed any
+		dec := json.NewDecoder(strings.NewReader(v))
+		dec.UseNumber()
+		if err := dec.Decode(&parsed); err == nil && !dec.More() {
+			ev.Headers[k] = fromJSONNumber(parsed)
+		} else {
+			ev.Headers[k] = v
+		}
+	}
+	if len(o.data) > 0 {
+		data := json.RawMessage(o.data)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(o.data)
+		}
+		var err error
+		if ev.Body, err = bodyFromJSON(data, o.hexBody); err != nil {
+			return ev, err
+		}
+	}
+	return ev, nil
+}
+
+func runCall(ctx context.Context, g *globalOpts, tr zerosvc.Transport, path string, o callOpts, out io.Writer) error {
+	var write func(io.Writer, *received) error
+	switch o.format {
+	case "json":
+		write = writeJSON
+	case "hex":
+		write = writeHex
+	default:
+		return fmt.Errorf("unknown format [%s]", o.format)
+	}
+	node, keys, err := g.decoderNode(o.pubkey, o.key)
+	if err != nil {
+		return err
+	}
+	if len(o.key) > 0 {
+		signer, err := loadSigner(o.key)
+		if err != nil {
+			return err
+		}
+		node.Lock()
+		node.Signer = signer
+		node.Unlock()
+	}
+	ev, err := callRequest(node, o)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	ev.ReplyTo = g.fullTopic("reply/" + node.Name + "/" + hex.EncodeToString(id))
+
+	discovery := make(chan *zerosvc.Message, 256)
+	if err := tr.Subscribe(g.fullTopic("discovery/#"), discovery); err != nil {
+		return err
+	}
+	replies := make(chan *zerosvc.Message, 256)
+	if err := tr.Subscribe(ev.ReplyTo, replies); err != nil {
+		return fmt.Errorf("error subscribing to reply topic: %w", err)
+	}
+	ev.TS = time.Now()
+	data, err := ev.Serialize()
+	if err != nil {
+		return err
+	}
+	sent := time.Now()
+	if err := tr.Publish(zerosvc.Message{Topic: g.fullTopic(path), Payload: data}); err != nil {
+		return err
+	}
+	timeout := time.NewTimer(o.timeout)
+	defer timeout.Stop()
+	seen := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout.C:
+			if seen == 0 {
+				return fmt.Errorf("no replies within %s", o.timeout)
+			}
+			if o.count > 0 && seen < o.count {
+				return fmt.Errorf("got %d of %d replies within %s", seen, o.count, o.timeout)
+			}
+			return nil
+		case m := <-discovery:
+			keys.learn(m)
+		case m := <-replies:
+			for len(discovery) > 0 {
+				keys.learn(<-discovery)
+			}
+			r := keys.decode(m, node, o.verify)
+			r.Latency = time.Since(sent)
+			if err := write(out, r); err != nil {
+				return err
+			}
+			seen++
+			if o.count > 0 && seen >= o.count {
+				return nil
+			}
+		}
+	}
+}
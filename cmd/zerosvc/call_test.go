@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestCall(t *testing.T) {
+	broker := zerosvc.NewMemoryBroker()
+	g := &globalOpts{root: "test"}
+	for _, name := range []string{"oven-1", "oven-2"} {
+		tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := zerosvc.NewNode(zerosvc.Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		requests, err := n.GetEventsCh("ovens/temp")
+		require.NoError(t, err)
+		go func() {
+			for req := range requests {
+				reply := n.PrepareReply(req)
+				reply.Body = []byte(`{"temp":180}`)
+				reply.Headers["kind"] = req.Headers["kind"]
+				_ = n.SendEvent(strings.TrimPrefix(req.ReplyTo, "test/"), reply)
+			}
+		}()
+	}
+
+	run := func(o callOpts) ([]eventOutput, error) {
+		out := &bytes.Buffer{}
+		o.format = "json"
+		err := runCall(context.Background(), g, testTransport(t, broker), "ovens/temp", o, out)
+		if out.Len() == 0 {
+			return nil, err
+		}
+		return subOutputs(t, out), err
+	}
+
+	start := time.Now()
+	outputs, err := run(callOpts{data: `{"unit":"C"}`, headers: stringList{"kind=\"cheese\""}, timeout: time.Second, count: 1})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second/2, "should return after first reply")
+	require.Len(t, outputs, 1)
+	assert.JSONEq(t, `{"temp":180}`, string(outputs[0].Body))
+	assert.Equal(t, "cheese", outputs[0].Headers["kind"])
+	assert.NotEmpty(t, outputs[0].Latency)
+	assert.Contains(t, outputs[0].Topic, "test/reply/")
+
+	outputs, err = run(callOpts{timeout: time.Millisecond * 200, count: 0})
+	require.NoError(t, err)
+	require.Len(t, outputs, 2, "broadcast should collect all replies")
+	assert.ElementsMatch(t, []string{"oven-1", "oven-2"}, []string{outputs[0].Node, outputs[1].Node})
+
+	outputs, err = run(callOpts{timeout: time.Millisecond * 200, count: 3})
+	assert.ErrorContains(t, err, "got 2 of 3")
+	assert.Len(t, outputs, 2)
+
+	g = &globalOpts{root: "other"}
+	_, err = run(callOpts{timeout: time.Millisecond * 50, count: 1})
+	assert.ErrorContains(t, err, "no replies")
+
+	_, err = run(callOpts{headers: stringList{"novalue"}, timeout: time.Millisecond})
+	assert.ErrorContains(t, err, "key=value")
+}
+
+func TestCallRequest(t *testing.T) {
+	node, err := (&globalOpts{root: "test"}).codecNode(zerosvc.Config{})
+	require.NoError(t, err)
+	ev, err := callRequest(node, callOpts{data: "plain text", headers: stringList{"n=3", "s=abc"}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain text"), ev.Body)
+	assert.Equal(t, int64(3), ev.Headers["n"])
+	assert.Equal(t, "abc", ev.Headers["s"])
+	ev, err = callRequest(node, callOpts{data: `"cafe"`, hexBody: true})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xca, 0xfe}, ev.Body)
+}
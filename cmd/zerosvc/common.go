@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// globalOpts are connection options shared by all commands talking to broker
+type globalOpts struct {
+	url   string
+	root  string
+	proto int
+}
+
+func envOr(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalOpts {
+	o := &globalOpts{}
+	fs.StringVar(&o.url, "url", envOr("ZEROSVC_URL", "tcp://127.0.0.1:1883"),
+		"broker URL; ssl:// URLs take ca, cert and certkey query options ($ZEROSVC_URL)")
+	fs.StringVar(&o.root, "root", envOr("ZEROSVC_ROOT", "zerosvc"), "event root ($ZEROSVC_ROOT)")
+	fs.IntVar(&o.proto, "proto", 5, "MQTT protocol version, 3 or 5")
+	return o
+}
+
+// fullTopic returns topic under event root
+func (o *globalOpts) fullTopic(path string) string {
+	return strings.TrimSuffix(o.root, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// connect connects raw transport to broker. CLI does not send heartbeats, so it does not show up in discovery
+func (o *globalOpts) connect() (tr zerosvc.Transport, disconnect func(), err error) {
+	u, err := url.Parse(o.url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing url [%s]: %w", o.url, err)
+	}
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	clientID := "zerosvc-cli-" + hex.EncodeToString(id)
+	switch o.proto {
+	case 3:
+		t, err := zerosvc.NewTransportMQTTv3(zerosvc.ConfigMQTTv3{ID: clientID, MQTTURL: []*url.URL{u}})
+		if err != nil {
+			return nil, nil, err
+		}
+		tr = t
+		disconnect = t.Disconnect
+	case 5:
+		t, err := zerosvc.NewTransportMQTTv5(zerosvc.ConfigMQTTv5{ID: clientID, MQTTURL: []*url.URL{u}})
+		if err != nil {
+			return nil, nil, err
+		}
+		tr = t
+		disconnect = func() { _ = t.Disconnect() }
+	default:
+		return nil, nil, fmt.Errorf("unsupported protocol version %d", o.proto)
+	}
+	if err := tr.Connect(zerosvc.Hooks{}, o.fullTopic("discovery/zerosvc-cli/"+clientID)); err != nil {
+		return nil, nil, fmt.Errorf("error connecting to %s: %w", u.Redacted(), err)
+	}
+	return tr, disconnect, nil
+}
+
+// codecNode returns node used only to encode and decode events; it is not connected anywhere
+func (o *globalOpts) codecNode(cfg zerosvc.Config) (*zerosvc.Node, error) {
+	tr, err := zerosvc.NewTransportDummy(zerosvc.ConfigDummy{})
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.NodeName) == 0 {
+		cfg.NodeName = zerosvc.GetFQDN() + "@zerosvc-cli"
+	}
+	cfg.Transport = tr
+	cfg.EventRoot = o.root
+	return zerosvc.NewNode(cfg)
+}
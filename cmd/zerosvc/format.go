@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// eventInput is JSON representation of event to publish
+type eventInput struct {
+	Headers map[string]any `json:"headers"`
+	// Body is used as-is if it is JSON string, else raw JSON is used as body
+	Body    json.RawMessage `json:"body"`
+	ReplyTo string          `json:"reply_to"`
+	// TraceID in hex, random if empty
+	TraceID string `json:"trace_id"`
+	Retain  bool   `json:"retain"`
+}
+
+// eventFromJSON builds event from eventInput JSON. If hexBody is set, string body is decoded as hex
+func eventFromJSON(n *zerosvc.Node, data []byte, hexBody bool) (ev zerosvc.Event, retain bool, err error) {
+	var in eventInput
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&in); err != nil {
+		return ev, false, fmt.Errorf("error parsing event JSON: %w", err)
+	}
+	if len(in.TraceID) > 0 {
+		traceID, err := hex.DecodeString(in.TraceID)
+		if err != nil {
+			return ev, false, fmt.Errorf("error decoding trace_id: %w", err)
+		}
+		ev = n.NewEvent(traceID)
+	} else {
+		ev = n.NewEvent()
+	}
+	ev.ReplyTo = in.ReplyTo
+	for k, v := range in.Headers {
+		ev.Headers[k] = fromJSONNumber(v)
+	}
+	ev.Body, err = bodyFromJSON(in.Body, hexBody)
+	return ev, in.Retain, err
+}
+
+// bodyFromJSON returns JSON string as-is (decoded from hex if hexBody is set) and any other JSON value raw
+func bodyFromJSON(data json.RawMessage, hexBody bool) ([]byte, error) {
+	var s string
+	switch {
+	case len(data) == 0 || string(data) == "null":
+		return nil, nil
+	case json.Unmarshal(data, &s) == nil:
+		if hexBody {
+			body, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding hex body: %w", err)
+			}
+			return body, nil
+		}
+		return []byte(s), nil
+	default:
+		return data, nil
+	}
+}
+
+// fromJSONNumber converts json.Number to int64 or float64, so it is encoded as number and not string
+func fromJSONNumber(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = fromJSONNumber(e)
+		}
+		return t
+	case []any:
+		for i, e := range t {
+			t[i] = fromJSONNumber(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// eventOutput is JSON representation of received event
+type eventOutput struct {
+	Topic    string          `json:"topic"`
+	Retained bool            `json:"retained,omitempty"`
+	Node     string          `json:"node,omitempty"`
+	UUID     string          `json:"uuid,omitempty"`
+	TS       *time.Time      `json:"ts,omitempty"`
+	TraceID  string          `json:"trace_id,omitempty"`
+	SpanID   string          `json:"span_id,omitempty"`
+	ReplyTo  string          `json:"reply_to,omitempty"`
+	Headers  map[string]any  `json:"headers,omitempty"`
+	Signed   bool            `json:"signed"`
+	Verified bool            `json:"verified"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyB64  string          `json:"body_b64,omitempty"`
+	Latency  string          `json:"latency,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	RawHex   string          `json:"raw_hex,omitempty"`
+}
+
+// received is decoded (or not) message
+type received struct {
+	Topic    string
+	Retained bool
+	Event    *zerosvc.Event
+	Verified bool
+	Err      error
+	Raw      []byte
+	// Latency is time since request was sent, for replies
+	Latency time.Duration
+}
+
+func (r *received) output() eventOutput {
+	out := eventOutput{Topic: r.Topic, Retained: r.Retained}
+	if r.Latency > 0 {
+		out.Latency = r.Latency.String()
+	}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+		out.RawHex = hex.EncodeToString(r.Raw)
+		return out
+	}
+	ev := r.Event
+	out.Node = ev.NodeName
+	out.UUID = ev.NodeUUID
+	if !ev.TS.IsZero() {
+		ts := ev.TS
+		out.TS = &ts
+	}
+	out.TraceID = hex.EncodeToString(ev.TraceID)
+	out.SpanID = hex.EncodeToString(ev.SpanID)
+	out.ReplyTo = ev.ReplyTo
+	if len(ev.Headers) > 0 {
+		out.Headers = jsonSafe(ev.Headers).(map[string]any)
+	}
+	out.Signed = len(ev.Signature) > 0
+	out.Verified = r.Verified
+	switch {
+	case len(ev.Body) == 0:
+	case json.Valid(ev.Body):
+		out.Body = ev.Body
+	case utf8.Valid(ev.Body):
+		out.Body, _ = json.Marshal(string(ev.Body))
+	default:
+		out.BodyB64 = base64.StdEncoding.EncodeToString(ev.Body)
+	}
+	return out
+}
+
+// jsonSafe converts values CBOR decoder produces and JSON can't encode, like maps with non-string keys
+func jsonSafe(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[k] = jsonSafe(e)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[fmt.Sprint(k)] = jsonSafe(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, e := range t {
+			out[i] = jsonSafe(e)
+		}
+		return out
+	case []byte:
+		return hex.EncodeToString(t)
+	default:
+		return v
+	}
+}
+
+func writeJSON(w io.Writer, r *received) error {
+	data, err := json.Marshal(r.output())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func writeHex(w io.Writer, r *received) error {
+	var b strings.Builder
+	o := r.output()
+	fmt.Fprintf(&b, "topic: %s", o.Topic)
+	if o.Retained {
+		b.WriteString(" (retained)")
+	}
+	b.WriteString("\n")
+	if len(o.Latency) > 0 {
+		fmt.Fprintf(&b, "latency: %s\n", o.Latency)
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "error: %s\n%s\n", r.Err, hex.Dump(r.Raw))
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	fmt.Fprintf(&b, "node: %s [%s]\n", o.Node, o.UUID)
+	if o.TS != nil {
+		fmt.Fprintf(&b, "ts: %s\n", o.TS.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, "trace: %s span: %s\n", o.TraceID, o.SpanID)
+	if len(o.ReplyTo) > 0 {
+		fmt.Fprintf(&b, "reply to: %s\n", o.ReplyTo)
+	}
+	fmt.Fprintf(&b, "signed: %t verified: %t\n", o.Signed, o.Verified)
+	keys := make([]string, 0, len(o.Headers))
+	for k := range o.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "header %s: %v\n", k, o.Headers[k])
+	}
+	fmt.Fprintf(&b, "body (%d bytes):\n%s\n", len(r.Event.Body), hex.Dump(r.Event.Body))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
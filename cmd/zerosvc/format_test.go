@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestEventFromJSON(t *testing.T) {
+	g := &globalOpts{root: "test"}
+	node, err := g.codecNode(zerosvc.Config{})
+	require.NoError(t, err)
+
+	ev, retain, err := eventFromJSON(node, []byte(`{"headers":{"n":1,"f":1.5,"s":"x"},"body":"cake","reply_to":"r","retain":true}`), false)
+	require.NoError(t, err)
+	assert.True(t, retain)
+	assert.Equal(t, []byte("cake"), ev.Body)
+	assert.Equal(t, "r", ev.ReplyTo)
+	assert.Equal(t, int64(1), ev.Headers["n"])
+	assert.Equal(t, 1.5, ev.Headers["f"])
+	assert.NotEmpty(t, ev.SpanID)
+
+	ev, _, err = eventFromJSON(node, []byte(`{"body":{"a":[1,2]},"trace_id":"0102"}`), false)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":[1,2]}`, string(ev.Body))
+	assert.Equal(t, []byte{1, 2}, ev.TraceID)
+
+	ev, _, err = eventFromJSON(node, []byte(`{"body":"00ff"}`), true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0xff}, ev.Body)
+
+	_, _, err = eventFromJSON(node, []byte(`{"body":"zz"}`), true)
+	assert.Error(t, err)
+	_, _, err = eventFromJSON(node, []byte(`not json`), false)
+	assert.Error(t, err)
+}
+
+func TestEventOutput(t *testing.T) {
+	ev := &zerosvc.Event{
+		NodeName: "node",
+		SpanID:   []byte{1},
+		Headers: map[string]any{
+			"nested": map[any]any{uint64(1): "one"},
+			"bin":    []byte{0xca, 0xfe},
+		},
+		Body: []byte{0xff, 0x00},
+	}
+	out := (&received{Topic: "t", Event: ev}).output()
+	assert.Equal(t, "01", out.SpanID)
+	assert.Equal(t, map[string]any{"1": "one"}, out.Headers["nested"])
+	assert.Equal(t, "cafe", out.Headers["bin"])
+	assert.Equal(t, "/wA=", out.BodyB64)
+	assert.Empty(t, out.Body)
+
+	ev.Body = []byte(`{"a":1}`)
+	assert.JSONEq(t, `{"a":1}`, string((&received{Event: ev}).output().Body))
+	ev.Body = []byte(`plain text`)
+	assert.Equal(t, `"plain text"`, string((&received{Event: ev}).output().Body))
+
+	out = (&received{Topic: "t", Err: assert.AnError, Raw: []byte{1}}).output()
+	assert.Equal(t, hex.EncodeToString([]byte{1}), out.RawHex)
+	assert.NotEmpty(t, out.Error)
+}
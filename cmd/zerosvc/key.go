@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+var keyCommands = map[string]func(args []string, stdin io.Reader, out io.Writer) error{
+	"gen":         keyGen,
+	"show":        keyShow,
+	"fingerprint": keyFingerprint,
+	"sign":        keySign,
+	"verify":      keyVerify,
+}
+
+func cmdKey(args []string) error {
+	if len(args) < 1 || keyCommands[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, `Usage: zerosvc key <command> [flags] [args]
+
+Commands:
+  gen          generate new key
+  show         show public key, fingerprint and encryption key of the key
+  fingerprint  print fingerprint of private or public key
+  sign         sign data with private key
+  verify       verify detached signature, or signed event
+
+Keys are read as PEM (PKCS#8 private, PKIX public) or base64 of raw key.
+Raw 32 byte key is a seed of private key when signing and public key everywhere else
+`)
+		return fmt.Errorf("key command required")
+	}
+	return keyCommands[args[0]](args[1:], os.Stdin, os.Stdout)
+}
+
+// readInput reads file named by the only argument, or stdin if there is none or it is "-"
+func readInput(args []string, stdin io.Reader) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(args[0])
+}
+
+func keyGen(args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("key gen", flag.ContinueOnError)
+	format := fs.String("format", "pem", "key format, pem or base64")
+	outFile := fs.String("out", "", "write private key to file instead of stdout")
+	pubFile := fs.String("pub-out", "", "also write public key to file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	privData, err := encodePrivateKey(priv, *format)
+	if err != nil {
+		return err
+	}
+	if len(*pubFile) > 0 {
+		pubData, err := encodePublicKey(pub, *format)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*pubFile, pubData, 0644); err != nil {
+			return err
+		}
+	}
+	if len(*outFile) > 0 {
+		if err := os.WriteFile(*outFile, privData, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "fingerprint: %s\n", zerosvc.PublicKeyFingerprint(pub))
+		return nil
+	}
+	_, err = out.Write(privData)
+	return err
+}
+
+func keyShow(args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("key show", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	data, err := readInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	// raw 32 bytes are shown as public key; private keys are written with full 64 bytes by "key gen"
+	priv, pub, err := zerosvc.ParseEd25519Key(data, false)
+	if err != nil {
+		return err
+	}
+	pubPEM, err := encodePublicKey(pub, "pem")
+	if err != nil {
+		return err
+	}
+	if priv != nil {
+		fmt.Fprintf(out, "type: Ed25519 private key\n")
+	} else {
+		fmt.Fprintf(out, "type: Ed25519 public key\n")
+	}
+	fmt.Fprintf(out, "public key: %s\n", base64.StdEncoding.EncodeToString(pub))
+	fmt.Fprintf(out, "fingerprint: %s\n", zerosvc.PublicKeyFingerprint(pub))
+	if priv != nil {
+		encKey, err := zerosvc.EncryptionKeyFromEd25519(priv)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "encryption key: %s\n", base64.StdEncoding.EncodeToString(encKey.PublicKey().Bytes()))
+		fmt.Fprintf(out, "encryption key fingerprint: %s\n", zerosvc.EncryptionKeyFingerprint(encKey.PublicKey()))
+	}
+	_, err = out.Write(pubPEM)
+	return err
+}
+
+func keyFingerprint(args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("key fingerprint", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc key fingerprint <key file|base64 public key>\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("key required")
+	}
+	pub, err := loadPublicKey(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, zerosvc.PublicKeyFingerprint(pub))
+	return err
+}
+
+func keySign(args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("key sign", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc key sign -key <file> [file|-]\n\nPrints base64 signature of the data\n\n")
+		fs.PrintDefaults()
+	}
+	keyPath := fs.String("key", "", "private key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*keyPath) == 0 {
+		fs.Usage()
+		return fmt.Errorf("key required")
+	}
+	signer, err := loadSigner(*keyPath)
+	if err != nil {
+		return err
+	}
+	data, err := readInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, base64.StdEncoding.EncodeToString(signer.Sign(data)))
+	return err
+}
+
+func keyVerify(args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("key verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: zerosvc key verify -pubkey <key> [-sig <signature>] [file|-]
+
+Verifies base64 detached signature of the data or, without -sig, signature of serialized event
+(as received from broker)
+
+`)
+		fs.PrintDefaults()
+	}
+	pubkey := fs.String("pubkey", "", "public key (file or base64)")
+	sig := fs.String("sig", "", "base64 detached signature")
+	isHex := fs.Bool("hex", false, "input is hex encoded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*pubkey) == 0 {
+		fs.Usage()
+		return fmt.Errorf("pubkey required")
+	}
+	pub, err := loadPublicKey(*pubkey)
+	if err != nil {
+		return err
+	}
+	verifier, err := zerosvc.SignerEd25519FromPub(pub)
+	if err != nil {
+		return err
+	}
+	data, err := readInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	if *isHex {
+		data, err = hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("error decoding hex input: %w", err)
+		}
+	}
+	if len(*sig) > 0 {
+		signature, err := base64.StdEncoding.DecodeString(*sig)
+		if err != nil {
+			return fmt.Errorf("error decoding signature: %w", err)
+		}
+		if !verifier.Verify(data, signature) {
+			return zerosvc.ErrSignatureInvalid{}
+		}
+		_, err = fmt.Fprintln(out, "signature OK")
+		return err
+	}
+	g := &globalOpts{}
+	node, err := g.codecNode(zerosvc.Config{})
+	if err != nil {
+		return err
+	}
+	node.PubkeyRetriever = func(nodeName string, nodeUUID string) (zerosvc.Verifier, bool) {
+		return verifier, true
+	}
+	ev, err := (&zerosvc.Event{}).Deserialize(data, node)
+	if err != nil {
+		return err
+	}
+	if len(ev.Signature) == 0 {
+		return fmt.Errorf("event is not signed")
+	}
+	_, err = fmt.Fprintf(out, "signature OK, event from %s [%s]\n", ev.NodeName, ev.NodeUUID)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestKeyCommands(t *testing.T) {
+	dir := t.TempDir()
+	out := &bytes.Buffer{}
+	run := func(cmd string, stdin string, args ...string) (string, error) {
+		out.Reset()
+		err := keyCommands[cmd](args, strings.NewReader(stdin), out)
+		return strings.TrimSpace(out.String()), err
+	}
+	pemKey := filepath.Join(dir, "node.pem")
+	pemPub := filepath.Join(dir, "node.pub.pem")
+	b64Key := filepath.Join(dir, "node.key")
+	b64Pub := filepath.Join(dir, "node.pub")
+
+	fp, err := run("gen", "", "-out", pemKey, "-pub-out", pemPub)
+	require.NoError(t, err)
+	assert.Contains(t, fp, "fingerprint: ")
+	fp = strings.TrimPrefix(fp, "fingerprint: ")
+	data, err := os.ReadFile(pemKey)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "BEGIN PRIVATE KEY")
+
+	// convert PEM key to base64 form
+	priv, err := loadPrivateKey(pemKey)
+	require.NoError(t, err)
+	b64, err := encodePrivateKey(priv, "base64")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(b64Key, b64, 0600))
+	b64, err = encodePublicKey(priv.Public().(ed25519.PublicKey), "base64")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(b64Pub, b64, 0600))
+
+	for _, k := range []string{pemKey, pemPub, b64Key, b64Pub, strings.TrimSpace(string(b64))} {
+		got, err := run("fingerprint", "", k)
+		require.NoError(t, err, k)
+		assert.Equal(t, fp, got, k)
+	}
+
+	show, err := run("show", "", pemKey)
+	require.NoError(t, err)
+	assert.Contains(t, show, "private key")
+	assert.Contains(t, show, "encryption key:")
+	assert.Contains(t, show, "BEGIN PUBLIC KEY")
+	show, err = run("show", "", pemPub)
+	require.NoError(t, err)
+	assert.Contains(t, show, "public key")
+	assert.NotContains(t, show, "encryption key:")
+
+	t.Run("detached", func(t *testing.T) {
+		sig, err := run("sign", "cake", "-key", b64Key)
+		require.NoError(t, err)
+		ok, err := run("verify", "cake", "-pubkey", pemPub, "-sig", sig)
+		require.NoError(t, err)
+		assert.Equal(t, "signature OK", ok)
+		_, err = run("verify", "lie", "-pubkey", pemPub, "-sig", sig)
+		assert.ErrorIs(t, err, zerosvc.ErrSignatureInvalid{})
+	})
+	t.Run("event", func(t *testing.T) {
+		g := &globalOpts{root: "test"}
+		node, err := g.codecNode(zerosvc.Config{NodeName: "signer"})
+		require.NoError(t, err)
+		signer, err := loadSigner(pemKey)
+		require.NoError(t, err)
+		node.Lock()
+		node.Signer = signer
+		node.Unlock()
+		ev := node.NewEvent()
+		ev.Body = []byte("cake")
+		raw, err := ev.Serialize()
+		require.NoError(t, err)
+		eventFile := filepath.Join(dir, "event")
+		require.NoError(t, os.WriteFile(eventFile, raw, 0600))
+
+		ok, err := run("verify", "", "-pubkey", b64Pub, eventFile)
+		require.NoError(t, err)
+		assert.Contains(t, ok, "signer")
+		_, err = run("verify", hex.EncodeToString(raw), "-pubkey", b64Pub, "-hex")
+		require.NoError(t, err)
+
+		_, otherPub := testKeyFile(t)
+		_, err = run("verify", "", "-pubkey", hex.EncodeToString(otherPub), eventFile)
+		assert.Error(t, err, "hex is not valid key")
+		other, _ := encodePublicKey(otherPub, "base64")
+		_, err = run("verify", "", "-pubkey", string(other), eventFile)
+		assert.ErrorIs(t, err, zerosvc.ErrSignatureInvalid{})
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	keyPath, pub := testKeyFile(t)
+	priv, err := loadPrivateKey(keyPath)
+	require.NoError(t, err)
+	seed, _ := encodePublicKey(priv.Seed(), "base64")
+	p, pp, err := zerosvc.ParseEd25519Key(seed, true)
+	require.NoError(t, err)
+	assert.Equal(t, priv, p)
+	assert.Equal(t, pub, pp)
+	p, pp, err = zerosvc.ParseEd25519Key(seed, false)
+	require.NoError(t, err)
+	assert.Nil(t, p, "32 bytes are public key if private is not expected")
+	assert.Len(t, pp, 32)
+
+	_, _, err = zerosvc.ParseEd25519Key([]byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), true)
+	assert.Error(t, err)
+	_, _, err = zerosvc.ParseEd25519Key([]byte("AAAA"), true)
+	assert.Error(t, err)
+	_, err = loadPrivateKey(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
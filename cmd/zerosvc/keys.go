@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// loadPrivateKey reads Ed25519 private key from file
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	priv, _, err := zerosvc.ParseEd25519Key(data, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if priv == nil {
+		return nil, fmt.Errorf("%s: private key required, got public one", path)
+	}
+	return priv, nil
+}
+
+// loadPublicKey reads Ed25519 public key from file or, if there is no such file, from argument itself.
+// Private key (except raw seed, which can't be told apart from public key) is also accepted
+func loadPublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(s)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte(s)
+	} else if err != nil {
+		return nil, err
+	}
+	_, pub, err := zerosvc.ParseEd25519Key(data, false)
+	return pub, err
+}
+
+func loadSigner(path string) (zerosvc.Signer, error) {
+	key, err := loadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return zerosvc.NewSignerEd25519(key)
+}
+
+// encodePrivateKey returns private key as PKCS#8 PEM or base64 of raw key
+func encodePrivateKey(priv ed25519.PrivateKey, format string) ([]byte, error) {
+	switch format {
+	case "pem":
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString(priv) + "\n"), nil
+	default:
+		return nil, fmt.Errorf("unknown key format [%s]", format)
+	}
+}
+
+// encodePublicKey returns public key as PKIX PEM or base64 of raw key
+func encodePublicKey(pub ed25519.PublicKey, format string) ([]byte, error) {
+	switch format {
+	case "pem":
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString(pub) + "\n"), nil
+	default:
+		return nil, fmt.Errorf("unknown key format [%s]", format)
+	}
+}
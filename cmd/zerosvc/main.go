@@ -0,0 +1,55 @@
+// zerosvc is command line tool for inspecting and generating zerosvc traffic
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"call":   {run: cmdCall, usage: "send request and print replies"},
+	"key":    {run: cmdKey, usage: "key management: gen, show, fingerprint, sign, verify"},
+	"nodes":  {run: cmdNodes, usage: "list nodes present in discovery"},
+	"pub":    {run: cmdPub, usage: "publish event"},
+	"record": {run: cmdRecord, usage: "record raw traffic to capture file"},
+	"replay": {run: cmdReplay, usage: "replay capture file to broker"},
+	"sub":    {run: cmdSub, usage: "subscribe and print decoded events"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for command flags\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "--help" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command [%s]\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+type nodesOpts struct {
+	format   string
+	watch    bool
+	wait     time.Duration
+	interval time.Duration
+}
+
+func cmdNodes(args []string) error {
+	fs := flag.NewFlagSet("nodes", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc nodes [flags]\n\nLists nodes present in discovery\n\n")
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := nodesOpts{}
+	fs.StringVar(&o.format, "format", "table", "output format, table or json")
+	fs.BoolVar(&o.watch, "watch", false, "keep running and show changes")
+	fs.DurationVar(&o.wait, "wait", time.Second*2, "how long to wait for discovery data")
+	fs.DurationVar(&o.interval, "interval", time.Second*2, "table refresh interval in watch mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.format != "table" && o.format != "json" {
+		return fmt.Errorf("unknown format [%s]", o.format)
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if o.watch {
+		return watchNodes(ctx, g, tr, o, os.Stdout)
+	}
+	nodes, err := collectNodes(ctx, g, tr, o.wait)
+	if err != nil {
+		return err
+	}
+	if o.format == "json" {
+		return writeNodesJSON(os.Stdout, nodes, time.Now())
+	}
+	return writeNodesTable(os.Stdout, nodes, time.Now())
+}
+
+// nodeOutput is JSON representation of discovered node
+type nodeOutput struct {
+	Name        string                     `json:"name"`
+	UUID        string                     `json:"uuid"`
+	TS          time.Time                  `json:"ts"`
+	Age         float64                    `json:"age"`
+	Services    map[string]zerosvc.Service `json:"services,omitempty"`
+	Fingerprint string                     `json:"fingerprint,omitempty"`
+	Envelope    uint8                      `json:"envelope"`
+	// State is only set in watch mode: up, down, or heartbeat
+	State string `json:"state,omitempty"`
+}
+
+func nodeToOutput(info zerosvc.NodeInfo, now time.Time) nodeOutput {
+	return nodeOutput{
+		Name:        info.Name,
+		UUID:        info.UUID,
+		TS:          info.TS,
+		Age:         now.Sub(info.TS).Round(time.Second).Seconds(),
+		Services:    info.Services,
+		Fingerprint: fingerprintOf(info.PublicKey),
+		Envelope:    info.Envelope,
+	}
+}
+
+// fingerprintOf returns fingerprint of base64 encoded public key
+func fingerprintOf(pub string) string {
+	if len(pub) == 0 {
+		return ""
+	}
+	raw, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return "invalid"
+	}
+	return zerosvc.PublicKeyFingerprint(raw)
+}
+
+// nodeSet keeps current discovery state
+type nodeSet map[string]zerosvc.NodeInfo
+
+// update applies discovery message and returns what changed: up, down, heartbeat or empty string if nothing
+func (s nodeSet) update(m *zerosvc.Message) (state string, info zerosvc.NodeInfo) {
+	if len(m.Payload) == 0 {
+		uuid := m.Topic[strings.LastIndex(m.Topic, "/")+1:]
+		info, found := s[uuid]
+		if !found {
+			return "", info
+		}
+		delete(s, uuid)
+		return "down", info
+	}
+	if err := json.Unmarshal(m.Payload, &info); err != nil || len(info.UUID) == 0 {
+		return "", info
+	}
+	_, found := s[info.UUID]
+	s[info.UUID] = info
+	if found {
+		return "heartbeat", info
+	}
+	return "up", info
+}
+
+func (s nodeSet) sorted() []zerosvc.NodeInfo {
+	out := make([]zerosvc.NodeInfo, 0, len(s))
+	for _, info := range s {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].UUID < out[j].UUID
+	})
+	return out
+}
+
+// collectNodes reads retained discovery messages. As there is no marker of the last retained message,
+// it stops after wait, or earlier if nothing arrives for a while
+func collectNodes(ctx context.Context, g *globalOpts, tr zerosvc.Transport, wait time.Duration) (nodeSet, error) {
+	messages := make(chan *zerosvc.Message, 256)
+	if err := tr.Subscribe(g.fullTopic("discovery/#"), messages); err != nil {
+		return nil, err
+	}
+	nodes := nodeSet{}
+	deadline := time.After(wait)
+	quiet := wait / 4
+	idle := time.NewTimer(wait)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nodes, nil
+		case <-deadline:
+			return nodes, nil
+		case <-idle.C:
+			return nodes, nil
+		case m := <-messages:
+			nodes.update(m)
+			idle.Reset(quiet)
+		}
+	}
+}
+
+func writeNodesJSON(w io.Writer, nodes nodeSet, now time.Time) error {
+	out := []nodeOutput{}
+	for _, info := range nodes.sorted() {
+		out = append(out, nodeToOutput(info, now))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func formatServices(services map[string]zerosvc.Service) string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		s := services[name]
+		status := "ok"
+		if !s.Ok {
+			status = "FAIL"
+		}
+		if len(s.Info) > 0 {
+			status += ": " + s.Info
+		}
+		parts = append(parts, fmt.Sprintf("%s(%s)", name, status))
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeNodesTable(w io.Writer, nodes nodeSet, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUUID\tAGE\tKEY\tSERVICES")
+	for _, info := range nodes.sorted() {
+		key := fingerprintOf(info.PublicKey)
+		if len(key) == 0 {
+			key = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			info.Name,
+			info.UUID,
+			now.Sub(info.TS).Round(time.Second),
+			key,
+			formatServices(info.Services),
+		)
+	}
+	return tw.Flush()
+}
+
+// watchNodes prints every change as JSON line, or periodically redraws the table
+func watchNodes(ctx context.Context, g *globalOpts, tr zerosvc.Transport, o nodesOpts, out io.Writer) error {
+	messages := make(chan *zerosvc.Message, 256)
+	if err := tr.Subscribe(g.fullTopic("discovery/#"), messages); err != nil {
+		return err
+	}
+	nodes := nodeSet{}
+	enc := json.NewEncoder(out)
+	redraw := time.NewTicker(o.interval)
+	defer redraw.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-redraw.C:
+			if o.format == "table" {
+				// clear screen
+				fmt.Fprint(out, "\033[H\033[2J")
+				fmt.Fprintf(out, "%s, %d nodes\n\n", time.Now().Format(time.RFC3339), len(nodes))
+				if err := writeNodesTable(out, nodes, time.Now()); err != nil {
+					return err
+				}
+			}
+		case m := <-messages:
+			state, info := nodes.update(m)
+			if state == "" || o.format != "json" {
+				continue
+			}
+			n := nodeToOutput(info, time.Now())
+			n.State = state
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		}
+	}
+}
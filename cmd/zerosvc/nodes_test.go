@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestNodes(t *testing.T) {
+	broker := zerosvc.NewMemoryBroker()
+	g := &globalOpts{root: "test"}
+	newNode := func(name string) (*zerosvc.Node, *zerosvc.TransportMemory) {
+		tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := zerosvc.NewNode(zerosvc.Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n, tr
+	}
+	baker, _ := newNode("baker")
+	baker.Lock()
+	baker.Signer, _ = zerosvc.NewSignerEd25519()
+	baker.Services["oven"] = zerosvc.Service{Ok: true}
+	baker.Services["mixer"] = zerosvc.Service{Ok: false, Info: "jammed"}
+	baker.Unlock()
+	baker.Heartbeat()
+	eater, eaterTr := newNode("eater")
+	require.Eventually(t, func() bool {
+		_, found := broker.Retained("test/discovery/eater/" + eater.UUID)
+		return found
+	}, time.Second, time.Millisecond*10)
+
+	nodes, err := collectNodes(context.Background(), g, testTransport(t, broker), time.Millisecond*200)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	table := &bytes.Buffer{}
+	require.NoError(t, writeNodesTable(table, nodes, time.Now()))
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "baker")
+	assert.Contains(t, lines[1], zerosvc.PublicKeyFingerprint(baker.Signer.PublicKey()))
+	assert.Contains(t, lines[1], "mixer(FAIL: jammed) oven(ok)")
+	assert.Contains(t, lines[2], "eater")
+
+	js := &bytes.Buffer{}
+	require.NoError(t, writeNodesJSON(js, nodes, time.Now()))
+	var out []nodeOutput
+	require.NoError(t, json.Unmarshal(js.Bytes(), &out))
+	require.Len(t, out, 2)
+	assert.Equal(t, baker.UUID, out[0].UUID)
+	assert.True(t, out[0].Services["oven"].Ok)
+	assert.Empty(t, out[1].Fingerprint)
+
+	t.Run("watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		buf := &syncBuffer{}
+		done := make(chan error)
+		go func() {
+			done <- watchNodes(ctx, g, testTransport(t, broker), nodesOpts{format: "json", interval: time.Hour}, buf)
+		}()
+		require.Eventually(t, func() bool { return strings.Count(buf.String(), "\n") == 2 }, time.Second, time.Millisecond*10)
+		eaterTr.Kill()
+		require.Eventually(t, func() bool { return strings.Count(buf.String(), "\n") == 3 }, time.Second, time.Millisecond*10)
+		cancel()
+		require.NoError(t, <-done)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var last nodeOutput
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &last))
+		assert.Equal(t, "down", last.State)
+		assert.Equal(t, "eater", last.Name)
+	})
+}
+
+type syncBuffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.String()
+}
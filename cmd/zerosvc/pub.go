@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+type pubOpts struct {
+	key     string
+	hexBody bool
+	raw     bool
+	retain  bool
+}
+
+func cmdPub(args []string) error {
+	fs := flag.NewFlagSet("pub", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: zerosvc pub [flags] <path> [event-json]
+
+Path is relative to event root. Event is read from stdin if not given or "-":
+
+  {"headers": {"k": "v"}, "body": "text or any JSON", "reply_to": "", "trace_id": "<hex>", "retain": false}
+
+`)
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := pubOpts{}
+	fs.StringVar(&o.key, "key", "", "private key file to sign event with")
+	fs.BoolVar(&o.hexBody, "hex", false, "string body is hex-encoded binary")
+	fs.BoolVar(&o.raw, "raw", false, "input is raw body, not event JSON")
+	fs.BoolVar(&o.retain, "retain", false, "publish as retained")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return fmt.Errorf("path required")
+	}
+	var input []byte
+	var err error
+	if fs.NArg() == 2 && fs.Arg(1) != "-" {
+		input = []byte(fs.Arg(1))
+	} else {
+		input, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading stdin: %w", err)
+		}
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	return runPub(g, tr, fs.Arg(0), input, o)
+}
+
+func runPub(g *globalOpts, tr zerosvc.Transport, path string, input []byte, o pubOpts) error {
+	node, err := g.codecNode(zerosvc.Config{})
+	if err != nil {
+		return err
+	}
+	if len(o.key) > 0 {
+		signer, err := loadSigner(o.key)
+		if err != nil {
+			return err
+		}
+		node.Lock()
+		node.Signer = signer
+		node.Unlock()
+	}
+	var ev zerosvc.Event
+	retain := o.retain
+	if o.raw {
+		ev = node.NewEvent()
+		ev.Body = input
+	} else {
+		var inRetain bool
+		ev, inRetain, err = eventFromJSON(node, input, o.hexBody)
+		if err != nil {
+			return err
+		}
+		retain = retain || inRetain
+	}
+	ev.TS = time.Now()
+	data, err := ev.Serialize()
+	if err != nil {
+		return err
+	}
+	return tr.Publish(zerosvc.Message{
+		Topic:   g.fullTopic(path),
+		Payload: data,
+		Retain:  retain,
+	})
+}
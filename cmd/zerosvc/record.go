@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+	"github.com/zerosvc/go-zerosvc/capture"
+)
+
+type recordOpts struct {
+	out   string
+	count int
+	key   string
+}
+
+func cmdRecord(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc record -out <file> [flags] [filter...]\n\nFilters are relative to event root, default is #\n\n")
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := recordOpts{}
+	fs.StringVar(&o.out, "out", "", "capture file, - for stdout")
+	fs.IntVar(&o.count, "count", 0, "exit after recording that many messages, 0 for no limit")
+	fs.StringVar(&o.key, "key", "", "private key file, used to decrypt events encrypted to it so their sender is recorded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(o.out) == 0 {
+		fs.Usage()
+		return fmt.Errorf("-out required")
+	}
+	filters := fs.Args()
+	if len(filters) == 0 {
+		filters = []string{"#"}
+	}
+	var out io.Writer = os.Stdout
+	if o.out != "-" {
+		f, err := os.Create(o.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	count, err := runRecord(ctx, g, tr, filters, o, out)
+	fmt.Fprintf(os.Stderr, "recorded %d messages\n", count)
+	return err
+}
+
+func runRecord(ctx context.Context, g *globalOpts, tr zerosvc.Transport, filters []string, o recordOpts, out io.Writer) (int, error) {
+	cfg := zerosvc.Config{}
+	if len(o.key) > 0 {
+		priv, err := loadPrivateKey(o.key)
+		if err != nil {
+			return 0, err
+		}
+		cfg.EncryptionKey, err = zerosvc.EncryptionKeyFromEd25519(priv)
+		if err != nil {
+			return 0, err
+		}
+	}
+	node, err := g.codecNode(cfg)
+	if err != nil {
+		return 0, err
+	}
+	w, err := capture.NewWriter(out)
+	if err != nil {
+		return 0, err
+	}
+	full := make([]string, len(filters))
+	for i, f := range filters {
+		full[i] = g.fullTopic(f)
+	}
+	return capture.Capture(ctx, capture.CaptureConfig{
+		Transport: tr,
+		Filters:   full,
+		Node:      node,
+		Count:     o.count,
+	}, w)
+}
+
+// stringList is flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+type replayOpts struct {
+	speed    float64
+	nodes    stringList
+	from     string
+	to       string
+	retain   bool
+	fromRoot string
+}
+
+func cmdReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc replay [flags] <file|->\n\n")
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := replayOpts{}
+	fs.Float64Var(&o.speed, "speed", 1, "speed multiplier of original timing, 0 to replay without delays")
+	fs.Var(&o.nodes, "node", "only replay events sent by that node name; can be repeated")
+	fs.StringVar(&o.from, "from", "", "only replay messages received at or after that time (RFC3339)")
+	fs.StringVar(&o.to, "to", "", "only replay messages received at or before that time (RFC3339)")
+	fs.BoolVar(&o.retain, "retain", false, "keep retain flag of recorded messages")
+	fs.StringVar(&o.fromRoot, "from-root", "", "event root messages were recorded under; their topics are moved under -root")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("capture file required")
+	}
+	var in io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	count, err := runReplay(ctx, g, tr, o, in)
+	fmt.Fprintf(os.Stderr, "replayed %d messages\n", count)
+	return err
+}
+
+func runReplay(ctx context.Context, g *globalOpts, tr zerosvc.Transport, o replayOpts, in io.Reader) (int, error) {
+	if o.speed < 0 {
+		return 0, fmt.Errorf("speed can't be negative")
+	}
+	cfg := capture.ReplayConfig{
+		Transport: tr,
+		Speed:     o.speed,
+		Nodes:     o.nodes,
+		Retain:    o.retain,
+	}
+	var err error
+	if len(o.from) > 0 {
+		if cfg.From, err = time.Parse(time.RFC3339Nano, o.from); err != nil {
+			return 0, fmt.Errorf("error parsing -from: %w", err)
+		}
+	}
+	if len(o.to) > 0 {
+		if cfg.To, err = time.Parse(time.RFC3339Nano, o.to); err != nil {
+			return 0, fmt.Errorf("error parsing -to: %w", err)
+		}
+	}
+	if len(o.fromRoot) > 0 {
+		prefix := strings.TrimSuffix(o.fromRoot, "/") + "/"
+		cfg.Rewrite = func(topic string) string {
+			if strings.HasPrefix(topic, prefix) {
+				return g.fullTopic(strings.TrimPrefix(topic, prefix))
+			}
+			return topic
+		}
+	}
+	r, err := capture.NewReader(in)
+	if err != nil {
+		return 0, err
+	}
+	return capture.Replay(ctx, cfg, r)
+}
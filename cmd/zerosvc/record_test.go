@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func TestRecordReplay(t *testing.T) {
+	broker := zerosvc.NewMemoryBroker()
+	g := &globalOpts{root: "test"}
+	capFile := &bytes.Buffer{}
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		count, err := runRecord(ctx, g, testTransport(t, broker), []string{"cakes/#"}, recordOpts{count: 2}, capFile)
+		assert.Equal(t, 2, count)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	tr := testTransport(t, broker)
+	require.NoError(t, runPub(g, tr, "cakes/a", []byte(`{"body":"a"}`), pubOpts{}))
+	require.NoError(t, runPub(g, tr, "cakes/b", []byte(`{"body":"b"}`), pubOpts{}))
+	require.NoError(t, <-done)
+
+	target := zerosvc.NewMemoryBroker()
+	sub := make(chan *zerosvc.Message, 10)
+	require.NoError(t, testTransport(t, target).Subscribe("prod/#", sub))
+	replayG := &globalOpts{root: "prod"}
+	count, err := runReplay(context.Background(), replayG, testTransport(t, target),
+		replayOpts{speed: 0, fromRoot: "test"}, bytes.NewReader(capFile.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	require.Len(t, sub, 2)
+	assert.Equal(t, "prod/cakes/a", (<-sub).Topic)
+	assert.Equal(t, "prod/cakes/b", (<-sub).Topic)
+
+	count, err = runReplay(context.Background(), replayG, testTransport(t, target),
+		replayOpts{speed: 0, nodes: stringList{"nobody"}}, bytes.NewReader(capFile.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	_, err = runReplay(context.Background(), replayG, testTransport(t, target),
+		replayOpts{from: "yesterday"}, bytes.NewReader(capFile.Bytes()))
+	assert.ErrorContains(t, err, "-from")
+}
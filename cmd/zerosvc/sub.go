@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+type subOpts struct {
+	format string
+	count  int
+	verify bool
+	pubkey string
+	key    string
+}
+
+func cmdSub(args []string) error {
+	fs := flag.NewFlagSet("sub", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zerosvc sub [flags] [filter...]\n\nFilters are relative to event root, default is #\n\n")
+		fs.PrintDefaults()
+	}
+	g := addGlobalFlags(fs)
+	o := subOpts{}
+	fs.StringVar(&o.format, "format", "json", "output format, json or hex")
+	fs.IntVar(&o.count, "count", 0, "exit after receiving that many messages, 0 for no limit")
+	fs.BoolVar(&o.verify, "verify", false, "treat events without verified signature as errors")
+	fs.StringVar(&o.pubkey, "pubkey", "", "Ed25519 public key (file or base64) used to verify nodes that are not in discovery")
+	fs.StringVar(&o.key, "key", "", "private key file, used to decrypt events encrypted to it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filters := fs.Args()
+	if len(filters) == 0 {
+		filters = []string{"#"}
+	}
+	tr, disconnect, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return runSub(ctx, g, tr, filters, o, os.Stdout)
+}
+
+// keyring holds public keys of nodes, learned from discovery
+type keyring struct {
+	keys     map[string]zerosvc.Verifier
+	fallback zerosvc.Verifier
+}
+
+func (k *keyring) retriever(nodeName string, nodeUUID string) (zerosvc.Verifier, bool) {
+	v, ok := k.keys[nodeUUID]
+	if !ok && k.fallback != nil {
+		v, ok = k.fallback, true
+	}
+	return v, ok
+}
+
+func (k *keyring) learn(m *zerosvc.Message) {
+	if len(m.Payload) == 0 {
+		return
+	}
+	var info zerosvc.NodeInfo
+	if err := json.Unmarshal(m.Payload, &info); err != nil || len(info.PublicKey) == 0 {
+		return
+	}
+	pub, err := base64.StdEncoding.DecodeString(info.PublicKey)
+	if err != nil {
+		return
+	}
+	v, err := zerosvc.SignerEd25519FromPub(pub)
+	if err != nil {
+		return
+	}
+	k.keys[info.UUID] = v
+}
+
+// decoderNode returns codec node verifying signatures with returned keyring, which falls back to pubkey
+// for nodes not learned from discovery. If key is set, it is used to decrypt events encrypted to it
+func (g *globalOpts) decoderNode(pubkey string, key string) (*zerosvc.Node, *keyring, error) {
+	keys := &keyring{keys: map[string]zerosvc.Verifier{}}
+	if len(pubkey) > 0 {
+		pub, err := loadPublicKey(pubkey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys.fallback, _ = zerosvc.SignerEd25519FromPub(pub)
+	}
+	cfg := zerosvc.Config{}
+	if len(key) > 0 {
+		priv, err := loadPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.EncryptionKey, err = zerosvc.EncryptionKeyFromEd25519(priv)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	node, err := g.codecNode(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	node.PubkeyRetriever = keys.retriever
+	return node, keys, nil
+}
+
+// decode deserializes message with node using keyring as its PubkeyRetriever.
+// If verify is set, events without verified signature are returned as errors
+func (k *keyring) decode(m *zerosvc.Message, node *zerosvc.Node, verify bool) *received {
+	r := &received{Topic: m.Topic, Retained: m.Retain, Raw: m.Payload}
+	if len(m.Payload) == 0 {
+		r.Err = fmt.Errorf("empty message (retained message cleared)")
+	} else {
+		r.Event, r.Err = (&zerosvc.Event{}).Deserialize(m.Payload, node)
+	}
+	if r.Err == nil {
+		r.Verified = r.Event.Verified
+		if verify && !r.Verified {
+			r.Err = fmt.Errorf("signature could not be verified")
+		}
+	}
+	return r
+}
+
+func runSub(ctx context.Context, g *globalOpts, tr zerosvc.Transport, filters []string, o subOpts, out io.Writer) error {
+	var write func(io.Writer, *received) error
+	switch o.format {
+	case "json":
+		write = writeJSON
+	case "hex":
+		write = writeHex
+	default:
+		return fmt.Errorf("unknown format [%s]", o.format)
+	}
+	node, keys, err := g.decoderNode(o.pubkey, o.key)
+	if err != nil {
+		return err
+	}
+	discovery := make(chan *zerosvc.Message, 256)
+	if err := tr.Subscribe(g.fullTopic("discovery/#"), discovery); err != nil {
+		return err
+	}
+	messages := make(chan *zerosvc.Message, 256)
+	for _, f := range filters {
+		if err := tr.Subscribe(g.fullTopic(f), messages); err != nil {
+			return fmt.Errorf("error subscribing to [%s]: %w", f, err)
+		}
+	}
+	seen := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m := <-discovery:
+			keys.learn(m)
+		case m := <-messages:
+			// drain pending discovery first so keys of new nodes are known
+			for len(discovery) > 0 {
+				keys.learn(<-discovery)
+			}
+			r := keys.decode(m, node, o.verify)
+			if err := write(out, r); err != nil {
+				return err
+			}
+			seen++
+			if o.count > 0 && seen >= o.count {
+				return nil
+			}
+		}
+	}
+}
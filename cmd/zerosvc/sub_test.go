@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func testTransport(t *testing.T, broker *zerosvc.MemoryBroker) *zerosvc.TransportMemory {
+	tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	require.NoError(t, tr.Connect(zerosvc.Hooks{}, "test/discovery/cli/"+t.Name()))
+	return tr
+}
+
+func testKeyFile(t *testing.T) (path string, pub ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	path = filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0600))
+	return path, pub
+}
+
+func subOutputs(t *testing.T, out *bytes.Buffer) []eventOutput {
+	outputs := []eventOutput{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var o eventOutput
+		require.NoError(t, json.Unmarshal([]byte(line), &o), line)
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
+
+func TestPubSub(t *testing.T) {
+	broker := zerosvc.NewMemoryBroker()
+	tr := testTransport(t, broker)
+	g := &globalOpts{root: "test"}
+	keyPath, pub := testKeyFile(t)
+
+	require.NoError(t, runPub(g, tr, "cakes/unsigned", []byte(`{"body":"plain","retain":true}`), pubOpts{}))
+	require.NoError(t, runPub(g, tr, "cakes/signed", []byte(`{"body":{"kind":"cheese"},"retain":true}`), pubOpts{key: keyPath}))
+	require.NoError(t, tr.Publish(zerosvc.Message{Topic: "test/cakes/garbage", Payload: []byte("junk"), Retain: true}))
+
+	run := func(o subOpts) []eventOutput {
+		out := &bytes.Buffer{}
+		o.count = 3
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, runSub(ctx, g, testTransport(t, broker), []string{"cakes/#"}, o, out))
+		outputs := subOutputs(t, out)
+		require.Len(t, outputs, 3)
+		byTopic := map[string]eventOutput{}
+		for _, o := range outputs {
+			byTopic[strings.TrimPrefix(o.Topic, "test/cakes/")] = o
+		}
+		return []eventOutput{byTopic["unsigned"], byTopic["signed"], byTopic["garbage"]}
+	}
+
+	t.Run("no keys", func(t *testing.T) {
+		outputs := run(subOpts{format: "json"})
+		assert.Equal(t, `"plain"`, string(outputs[0].Body))
+		assert.False(t, outputs[0].Signed)
+		assert.JSONEq(t, `{"kind":"cheese"}`, string(outputs[1].Body))
+		assert.True(t, outputs[1].Signed)
+		assert.False(t, outputs[1].Verified)
+		assert.NotEmpty(t, outputs[2].Error)
+		assert.Equal(t, "6a756e6b", outputs[2].RawHex)
+	})
+	t.Run("pubkey", func(t *testing.T) {
+		outputs := run(subOpts{format: "json", pubkey: base64.StdEncoding.EncodeToString(pub), verify: true})
+		assert.NotEmpty(t, outputs[0].Error, "unsigned event should fail with -verify")
+		assert.True(t, outputs[1].Verified)
+		_, other := testKeyFile(t)
+		outputs = run(subOpts{format: "json", pubkey: base64.StdEncoding.EncodeToString(other)})
+		assert.NotEmpty(t, outputs[1].Error, "bad signature")
+	})
+	t.Run("discovery", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sub := testTransport(t, broker)
+		node, err := g.codecNode(zerosvc.Config{})
+		require.NoError(t, err)
+		info, _ := json.Marshal(zerosvc.NodeInfo{
+			Name:      node.Name,
+			UUID:      node.UUID,
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+		})
+		require.NoError(t, tr.Publish(zerosvc.Message{Topic: "test/discovery/" + node.Name + "/" + node.UUID, Payload: info, Retain: true}))
+		require.NoError(t, runSub(ctx, g, sub, []string{"cakes/signed"}, subOpts{format: "json", count: 1}, out))
+		outputs := subOutputs(t, out)
+		require.Len(t, outputs, 1)
+		assert.True(t, outputs[0].Verified)
+	})
+	t.Run("hex", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, runSub(ctx, g, testTransport(t, broker), []string{"cakes/unsigned"}, subOpts{format: "hex", count: 1}, out))
+		assert.Contains(t, out.String(), "topic: test/cakes/unsigned (retained)")
+		assert.Contains(t, out.String(), "|plain|")
+	})
+	assert.Error(t, runSub(context.Background(), g, tr, nil, subOpts{format: "yaml"}, &bytes.Buffer{}))
+}
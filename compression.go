@@ -0,0 +1,124 @@
+package zerosvc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+)
+
+// Compression selects algorithm used to compress event payload
+type Compression uint8
+
+const (
+	// CompressionDefault uses whatever node is configured with
+	CompressionDefault Compression = iota
+	CompressionNone
+	CompressionGzip
+	CompressionZstd
+	CompressionSnappy
+)
+
+const (
+	// DefaultCompressionThreshold is size under which payloads are sent uncompressed
+	DefaultCompressionThreshold = 1024
+	// DefaultMaxDecompressedSize is limit of decompressed payload size if not configured
+	DefaultMaxDecompressedSize = 64 * 1024 * 1024
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionDefault:
+		return "default"
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+var zstdEncoder *zstd.Encoder
+var zstdEncoderOnce sync.Once
+
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		b := bytes.Buffer{}
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case CompressionZstd:
+		zstdEncoderOnce.Do(func() {
+			// only fails on invalid options
+			zstdEncoder, _ = zstd.NewWriter(nil)
+		})
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+}
+
+// decompress decompresses data, failing if output would be bigger than maxSize
+func decompress(c Compression, data []byte, maxSize int) ([]byte, error) {
+	var r io.Reader
+	switch c {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data),
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(maxSize)+1),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case CompressionSnappy:
+		l, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing snappy: %w", err)
+		}
+		if l > maxSize {
+			return nil, fmt.Errorf("decompressed size %d exceeds limit of %d", l, maxSize)
+		}
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s: %w", c, err)
+	}
+	if len(out) > maxSize {
+		return nil, fmt.Errorf("decompressed size exceeds limit of %d", maxSize)
+	}
+	return out, nil
+}
+
+// SetCompression overrides node compression settings for this event.
+// Any algorithm other than CompressionDefault is applied regardless of node's size threshold
+func (e *Event) SetCompression(c Compression) {
+	e.compression = c
+}
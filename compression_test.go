@@ -0,0 +1,84 @@
+package zerosvc
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("cake is a lie "), 1000)
+	for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		t.Run(c.String(), func(t *testing.T) {
+			compressed, err := compress(c, data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+			out, err := decompress(c, compressed, len(data))
+			require.NoError(t, err)
+			assert.Equal(t, data, out)
+			_, err = decompress(c, compressed, len(data)-1)
+			assert.ErrorContains(t, err, "exceeds")
+		})
+	}
+	_, err := compress(Compression(99), data)
+	assert.Error(t, err)
+}
+
+func TestEventCompression(t *testing.T) {
+	signer, err := NewSignerEd25519()
+	require.NoError(t, err)
+	node := getTestDummyNode(t, Config{Compression: CompressionZstd, CompressionThreshold: 512, Signer: signer})
+	big := bytes.Repeat([]byte("chocolate"), 1000)
+
+	t.Run("above threshold", func(t *testing.T) {
+		ev := node.NewEvent()
+		ev.Body = big
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		assert.Less(t, len(data), len(big))
+		env, err := parseEnvelope(data)
+		require.NoError(t, err)
+		assert.Equal(t, CompressionZstd, env.Compression)
+		out, err := (&Event{}).Deserialize(data, node)
+		require.NoError(t, err)
+		assert.Equal(t, big, out.Body)
+	})
+	t.Run("below threshold", func(t *testing.T) {
+		ev := node.NewEvent()
+		ev.Body = []byte("cake")
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		env, err := parseEnvelope(data)
+		require.NoError(t, err)
+		assert.Zero(t, env.Flags&EnvelopeFlagCompressed)
+	})
+	t.Run("per event", func(t *testing.T) {
+		ev := node.NewEvent()
+		ev.Body = []byte("cake")
+		ev.SetCompression(CompressionGzip)
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		env, err := parseEnvelope(data)
+		require.NoError(t, err)
+		assert.Equal(t, CompressionGzip, env.Compression)
+		out, err := (&Event{}).Deserialize(data, node)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("cake"), out.Body)
+
+		ev.Body = big
+		ev.SetCompression(CompressionNone)
+		data, err = ev.Serialize()
+		require.NoError(t, err)
+		assert.Greater(t, len(data), len(big))
+	})
+	t.Run("decompression limit", func(t *testing.T) {
+		receiver := getTestDummyNode(t, Config{MaxDecompressedSize: 1024, Signer: signer})
+		ev := node.NewEvent()
+		ev.Body = big
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		_, err = (&Event{}).Deserialize(data, receiver)
+		assert.ErrorContains(t, err, "exceeds")
+	})
+}
@@ -0,0 +1,36 @@
+package zerosvc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dead-letter event headers
+const (
+	// HeaderDeadLetterTopic is topic the event was received on
+	HeaderDeadLetterTopic = "_deadletter_topic"
+	// HeaderDeadLetterError is the reason event was rejected
+	HeaderDeadLetterError = "_deadletter_error"
+	// HeaderDeadLetterNode is name of the node that rejected the event
+	HeaderDeadLetterNode = "_deadletter_node"
+)
+
+// deadLetter republishes raw message that failed to decode or was rejected to dead-letter path, if one is configured.
+// Messages received on the dead-letter path itself are never re-sent, so a bad dead-letter subscriber can't loop
+func (n *Node) deadLetter(m *Message, reason error) {
+	if len(n.deadLetterPath) == 0 {
+		return
+	}
+	dlTopic := n.eventRoot + "/" + n.deadLetterPath
+	if m.Topic == dlTopic || strings.HasPrefix(m.Topic, dlTopic+"/") {
+		return
+	}
+	ev := n.NewEvent()
+	ev.Body = m.Payload
+	ev.Headers[HeaderDeadLetterTopic] = m.Topic
+	ev.Headers[HeaderDeadLetterError] = reason.Error()
+	ev.Headers[HeaderDeadLetterNode] = n.Name
+	if err := n.SendEvent(n.deadLetterPath, ev); err != nil {
+		n.reportError(fmt.Errorf("error sending event from [%s] to dead-letter: %w", m.Topic, err))
+	}
+}
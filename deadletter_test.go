@@ -0,0 +1,58 @@
+package zerosvc
+
+import (
+	"fmt"
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDeadLetter(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	node, err := NewNode(Config{
+		NodeName:       "receiver",
+		Transport:      tr,
+		EventRoot:      "test",
+		DeadLetterPath: "/deadletter/events/",
+	})
+	require.NoError(t, err)
+	node.UseReceive(func(next ReceiveHandler) ReceiveHandler {
+		return func(topic string, ev *Event) error {
+			if string(ev.Body) == "stale" {
+				return fmt.Errorf("stale cake")
+			}
+			return next(topic, ev)
+		}
+	})
+	events, err := node.GetEventsCh("cakes/#")
+	require.NoError(t, err)
+	deadletter, err := node.GetEventsCh("deadletter/#")
+	require.NoError(t, err)
+
+	require.NoError(t, tr.Publish(Message{Topic: "test/cakes/bad", Payload: []byte("not an event")}))
+	dl := goneric.ChanToSliceNTimeout(deadletter, 1, time.Second)
+	require.Len(t, dl, 1)
+	assert.Equal(t, []byte("not an event"), dl[0].Body)
+	assert.Equal(t, "test/cakes/bad", dl[0].Headers[HeaderDeadLetterTopic])
+	assert.Equal(t, "receiver", dl[0].Headers[HeaderDeadLetterNode])
+	assert.NotEmpty(t, dl[0].Headers[HeaderDeadLetterError])
+
+	ev := node.NewEvent()
+	ev.Body = []byte("stale")
+	require.NoError(t, node.SendEvent("cakes/stale", ev))
+	dl = goneric.ChanToSliceNTimeout(deadletter, 1, time.Second)
+	require.Len(t, dl, 1)
+	assert.Equal(t, "stale cake", dl[0].Headers[HeaderDeadLetterError])
+	rejected, err := (&Event{}).Deserialize(dl[0].Body, node)
+	require.NoError(t, err)
+	assert.Equal(t, ev.SpanID, rejected.SpanID)
+	assert.Len(t, events, 0)
+
+	// garbage on dead-letter topic itself is not re-sent
+	require.NoError(t, tr.Publish(Message{Topic: "test/deadletter/events", Payload: []byte("junk")}))
+	assert.Empty(t, goneric.ChanToSliceNTimeout(deadletter, 1, time.Millisecond*100))
+}
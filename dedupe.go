@@ -0,0 +1,48 @@
+package zerosvc
+
+import (
+	"time"
+)
+
+const (
+	DefaultDedupeTTL       = time.Minute * 10
+	DefaultDedupeCacheSize = 10000
+)
+
+type DedupeConfig struct {
+	// TTL is how long event is remembered, DefaultDedupeTTL if not set.
+	// Should be longer than transport's worst case redelivery delay (reconnect + session resume)
+	TTL time.Duration
+	// CacheSize is number of recently seen events remembered, DefaultDedupeCacheSize if not set
+	CacheSize int
+}
+
+// Deduper drops events that were already delivered to the subscription, making at-least-once
+// transports (QoS1 redelivery) effectively exactly-once for handlers, within TTL.
+// Events are identified by (NodeUUID, SpanID); events without SpanID are never deduplicated.
+// It is per subscription, so same event matching two subscriptions is delivered to both
+type Deduper struct {
+	seen *seenCache
+	now  func() time.Time
+}
+
+func NewDeduper(cfg DedupeConfig) *Deduper {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultDedupeTTL
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = DefaultDedupeCacheSize
+	}
+	return &Deduper{
+		seen: newSeenCache(cfg.CacheSize, cfg.TTL),
+		now:  time.Now,
+	}
+}
+
+// Seen returns true if event was already seen by subscription with given filter. Event is remembered as seen.
+func (d *Deduper) Seen(filter string, ev *Event) bool {
+	if len(ev.SpanID) == 0 {
+		return false
+	}
+	return d.seen.checkAndAdd(filter+"\000"+ev.NodeUUID+"\000"+string(ev.SpanID), d.now())
+}
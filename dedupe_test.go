@@ -0,0 +1,51 @@
+package zerosvc
+
+import (
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	node := getTestDummyNode(t)
+	d := NewDeduper(DedupeConfig{TTL: time.Minute, CacheSize: 4})
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	ev := node.NewEvent()
+	assert.False(t, d.Seen("a/#", &ev))
+	assert.True(t, d.Seen("a/#", &ev))
+	assert.False(t, d.Seen("#", &ev), "other subscription should still get the event")
+	now = now.Add(time.Minute * 2)
+	assert.False(t, d.Seen("a/#", &ev), "expired")
+	assert.False(t, d.Seen("a/#", &Event{}))
+	assert.False(t, d.Seen("a/#", &Event{}))
+}
+
+func TestNodeDedupe(t *testing.T) {
+	tr, err := NewTransportMemory(ConfigMemory{})
+	require.NoError(t, err)
+	node, err := NewNode(Config{
+		NodeName:  "dedupe",
+		Transport: tr,
+		EventRoot: "test",
+		Dedupe:    NewDeduper(DedupeConfig{}),
+	})
+	require.NoError(t, err)
+	ch, err := node.GetEventsCh("cakes/#")
+	require.NoError(t, err)
+	ev := node.NewEvent()
+	payload, err := ev.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, tr.Publish(Message{Topic: "test/cakes/1", Payload: payload, Duplicate: true}))
+	require.NoError(t, tr.Publish(Message{Topic: "test/cakes/1", Payload: payload, Duplicate: true}))
+	ev2 := node.NewEvent()
+	require.NoError(t, node.SendEvent("cakes/2", ev2))
+	got := goneric.ChanToSliceNTimeout(ch, 3, time.Millisecond*200)
+	require.Len(t, got, 2)
+	assert.Equal(t, ev.SpanID, got[0].SpanID)
+	assert.True(t, got[0].Redelivered)
+	assert.Equal(t, ev2.SpanID, got[1].SpanID)
+	assert.False(t, got[1].Redelivered)
+}
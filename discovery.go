@@ -0,0 +1,140 @@
+package zerosvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DiscoveryConfig struct {
+	Node *Node
+	// MaxAge after which node that stopped sending heartbeats is forgotten, 3x node's heartbeat interval if 0.
+	// Age is counted from NodeInfo.TS of last heartbeat, so retained heartbeat of long-dead node is not revived.
+	// Nodes that disconnect are removed right away by their last-will
+	MaxAge time.Duration
+	// NegotiateEnvelope makes node send events in newest envelope version that every node present in discovery
+	// advertises in NodeInfo.Envelope; nodes that don't advertise it can only decode EnvelopeV0.
+	// Node that compresses or encrypts by default never goes down to EnvelopeV0, as it could not send anything;
+	// old nodes just can't decode its events.
+	// Overrides Config.LegacyEnvelope
+	NegotiateEnvelope bool
+}
+
+// Discovery tracks nodes present in discovery, from their heartbeats
+type Discovery struct {
+	cfg   DiscoveryConfig
+	nodes map[string]NodeInfo
+	// refused is set while downgrade to EnvelopeV0 is refused, so it is logged once
+	refused bool
+	sync.RWMutex
+}
+
+func NewDiscovery(cfg DiscoveryConfig) (*Discovery, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = cfg.Node.heartbeatInterval * 3
+	}
+	d := &Discovery{
+		cfg:   cfg,
+		nodes: map[string]NodeInfo{},
+	}
+	messages := make(chan *Message, 64)
+	if err := cfg.Node.tr.Subscribe(cfg.Node.eventRoot+"/discovery/#", messages); err != nil {
+		return nil, err
+	}
+	go d.receive(messages)
+	return d, nil
+}
+
+func (d *Discovery) receive(messages chan *Message) {
+	for m := range messages {
+		uuid := m.Topic[strings.LastIndex(m.Topic, "/")+1:]
+		if len(m.Payload) == 0 {
+			d.Lock()
+			delete(d.nodes, uuid)
+			d.negotiate()
+			d.Unlock()
+			continue
+		}
+		var info NodeInfo
+		if err := json.Unmarshal(m.Payload, &info); err != nil || len(info.UUID) == 0 {
+			d.cfg.Node.l.Debugf("bad discovery message on [%s]: %s", m.Topic, err)
+			continue
+		}
+		d.Lock()
+		d.nodes[info.UUID] = info
+		d.negotiate()
+		d.Unlock()
+	}
+}
+
+// negotiate sets node's envelope version to the newest one every present node can decode. Must be called with lock held
+func (d *Discovery) negotiate() {
+	if !d.cfg.NegotiateEnvelope {
+		return
+	}
+	version := EnvelopeLatest
+	for _, info := range d.nodes {
+		if d.stale(info) {
+			continue
+		}
+		if info.Envelope < version {
+			version = info.Envelope
+		}
+	}
+	if version == EnvelopeV0 && d.cfg.Node.needsVersionedEnvelope() {
+		if !d.refused {
+			d.cfg.Node.l.Warnf("node compresses or encrypts events, not switching to envelope version %d required by some nodes in discovery", version)
+			d.refused = true
+		}
+		version = EnvelopeLatest
+	} else {
+		d.refused = false
+	}
+	if old := d.cfg.Node.EnvelopeVersion(); old != version {
+		d.cfg.Node.l.Infof("switching envelope version from %d to %d", old, version)
+		_ = d.cfg.Node.SetEnvelopeVersion(version)
+	}
+}
+
+// stale returns true if node's last heartbeat is older than MaxAge
+func (d *Discovery) stale(info NodeInfo) bool {
+	return time.Since(info.TS) > d.cfg.MaxAge
+}
+
+// Nodes returns nodes currently present in discovery, sorted by name and UUID
+func (d *Discovery) Nodes() []NodeInfo {
+	d.Lock()
+	defer d.Unlock()
+	out := make([]NodeInfo, 0, len(d.nodes))
+	for uuid, info := range d.nodes {
+		if d.stale(info) {
+			delete(d.nodes, uuid)
+			continue
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].UUID < out[j].UUID
+	})
+	return out
+}
+
+// Get returns discovery data of node with given UUID
+func (d *Discovery) Get(uuid string) (info NodeInfo, found bool) {
+	d.RLock()
+	defer d.RUnlock()
+	info, found = d.nodes[uuid]
+	if found && d.stale(info) {
+		return NodeInfo{}, false
+	}
+	return info, found
+}
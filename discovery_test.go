@@ -0,0 +1,99 @@
+package zerosvc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscovery(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string) (*Node, *TransportMemory) {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n, tr
+	}
+	watcher, _ := newNode("watcher")
+	b, trB := newNode("b")
+	a, _ := newNode("a")
+	d, err := NewDiscovery(DiscoveryConfig{Node: watcher})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(d.Nodes()) == 3 }, time.Second, time.Millisecond*5)
+	nodes := d.Nodes()
+	assert.Equal(t, []string{"a", "b", "watcher"}, []string{nodes[0].Name, nodes[1].Name, nodes[2].Name})
+	info, found := d.Get(a.UUID)
+	require.True(t, found)
+	assert.Equal(t, "a", info.Name)
+
+	trB.Kill()
+	require.Eventually(t, func() bool { return len(d.Nodes()) == 2 }, time.Second, time.Millisecond*5)
+	_, found = d.Get(b.UUID)
+	assert.False(t, found, "last-will should remove node")
+
+	// heartbeat TS, not time of receiving, decides whether node is stale
+	old, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	require.NoError(t, old.Connect(Hooks{}, "test/discovery/old/old-uuid"))
+	require.NoError(t, old.HeartbeatMessage(Message{
+		Payload: []byte(`{"name":"old","uuid":"old-uuid","ts":"` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}`),
+	}))
+	stale, err := NewDiscovery(DiscoveryConfig{Node: watcher, MaxAge: time.Minute})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(stale.Nodes()) == 2 }, time.Second, time.Millisecond*5)
+	_, found = stale.Get("old-uuid")
+	assert.False(t, found, "retained heartbeat with old timestamp should be stale")
+}
+
+func TestDiscoveryNegotiateEnvelope(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	n, err := NewNode(Config{NodeName: "new", Transport: tr, EventRoot: "test"})
+	require.NoError(t, err)
+	_, err = NewDiscovery(DiscoveryConfig{Node: n, NegotiateEnvelope: true})
+	require.NoError(t, err)
+	assert.Equal(t, EnvelopeLatest, n.EnvelopeVersion())
+
+	legacy, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Connect(Hooks{}, "test/discovery/old/old-uuid"))
+	// node from before envelope versioning does not advertise it
+	require.NoError(t, legacy.HeartbeatMessage(Message{
+		Payload: []byte(`{"name":"old","uuid":"old-uuid","ts":"` + time.Now().Format(time.RFC3339) + `"}`),
+	}))
+	require.Eventually(t, func() bool { return n.EnvelopeVersion() == EnvelopeV0 }, time.Second, time.Millisecond*5)
+	ev := n.NewEvent()
+	data, err := ev.Serialize()
+	require.NoError(t, err)
+	assert.NotEqual(t, envelopeMagic, data[:2], "should fall back to v0 framing")
+
+	legacy.Kill()
+	require.Eventually(t, func() bool { return n.EnvelopeVersion() == EnvelopeLatest }, time.Second, time.Millisecond*5)
+}
+
+func TestDiscoveryNegotiateEnvelopeCompression(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	n, err := NewNode(Config{NodeName: "new", Transport: tr, EventRoot: "test", Compression: CompressionZstd})
+	require.NoError(t, err)
+	_, err = NewDiscovery(DiscoveryConfig{Node: n, NegotiateEnvelope: true})
+	require.NoError(t, err)
+
+	legacy, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Connect(Hooks{}, "test/discovery/old/old-uuid"))
+	require.NoError(t, legacy.HeartbeatMessage(Message{
+		Payload: []byte(`{"name":"old","uuid":"old-uuid","ts":"` + time.Now().Format(time.RFC3339) + `"}`),
+	}))
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, EnvelopeLatest, n.EnvelopeVersion(), "compressing node should refuse downgrade")
+	ev := n.NewEvent()
+	ev.Body = make([]byte, 4096)
+	_, err = ev.Serialize()
+	require.NoError(t, err)
+}
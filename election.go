@@ -0,0 +1,344 @@
+package zerosvc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultElectionSettle = time.Second * 2
+
+type ElectionConfig struct {
+	Node *Node
+	// Name of the election; all candidates using the same name compete for one leadership
+	Name string
+	// RenewInterval is how often claim is refreshed, node's heartbeat interval if 0
+	RenewInterval time.Duration
+	// Lease is how long claim is valid without renewal, 3*RenewInterval if 0.
+	// It is only a fallback for when node's will did not get thru, so it can be generous
+	Lease time.Duration
+	// Settle is how long after start node only listens for existing claims before it can become the leader,
+	// so it does not briefly take over before learning about current one. DefaultElectionSettle if 0
+	Settle time.Duration
+	// OnElected is called when this node becomes the leader
+	OnElected func()
+	// OnDemoted is called when this node stops being the leader
+	OnDemoted func()
+}
+
+// LeaderElection elects single leader out of all nodes running it with the same name.
+//
+// Every candidate keeps a retained claim event on election/<name>/<node uuid>. Candidate is valid while
+// its claim lease has not expired and its node is present in discovery; node's last-will clears discovery entry,
+// so crashed leader is replaced without waiting for lease to run out.
+// Leader is the valid candidate with oldest claim, ties are broken by lower node UUID,
+// so every candidate with the same view picks the same leader.
+//
+// Lease validity is checked against local clock, so clocks should be reasonably in sync.
+// As with any lease-based scheme there is a window (up to the will delivery delay or lease) where
+// old leader did not notice it lost connection while new one was already elected.
+type LeaderElection struct {
+	cfg         ElectionConfig
+	n           *Node
+	path        string
+	since       time.Time
+	leader      atomic.Bool
+	started     atomic.Bool
+	settled     atomic.Bool
+	stop        chan bool
+	transitions chan struct{}
+	sync.Mutex
+	candidates map[string]electionCandidate
+	alive      map[string]bool
+	current    electionCandidate
+}
+
+// electionClaim carries its own timestamps as unix nanoseconds, event TS is only second-precision on the wire
+type electionClaim struct {
+	Since   int64         `cbor:"since" json:"since"`
+	Renewed int64         `cbor:"renewed" json:"renewed"`
+	Lease   time.Duration `cbor:"lease" json:"lease"`
+}
+
+type electionCandidate struct {
+	Name    string
+	UUID    string
+	Since   time.Time
+	Expires time.Time
+}
+
+func NewLeaderElection(cfg ElectionConfig) (*LeaderElection, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if len(cfg.Name) == 0 || strings.ContainsAny(cfg.Name, "/+#") {
+		return nil, fmt.Errorf("invalid election name [%s]", cfg.Name)
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = cfg.Node.heartbeatInterval
+	}
+	if cfg.Settle <= 0 {
+		cfg.Settle = DefaultElectionSettle
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = cfg.RenewInterval * 3
+	}
+	if cfg.Lease <= cfg.RenewInterval {
+		return nil, fmt.Errorf("lease [%s] must be longer than renew interval [%s]", cfg.Lease, cfg.RenewInterval)
+	}
+	return &LeaderElection{
+		cfg:         cfg,
+		n:           cfg.Node,
+		path:        "election/" + cfg.Name,
+		stop:        make(chan bool),
+		transitions: make(chan struct{}, 1),
+		candidates:  map[string]electionCandidate{},
+		alive:       map[string]bool{},
+	}, nil
+}
+
+// Start claims candidacy and starts following the election
+func (e *LeaderElection) Start() error {
+	if !e.started.CompareAndSwap(false, true) {
+		return fmt.Errorf("election already started")
+	}
+	e.since = time.Unix(0, time.Now().UnixNano())
+	go e.callbacks()
+	if err := e.renew(); err != nil {
+		return fmt.Errorf("error sending claim: %w", err)
+	}
+	messages := make(chan *Message, 64)
+	go e.receive(messages)
+	root := e.n.eventRoot + "/"
+	if err := e.n.tr.Subscribe(root+"discovery/#", messages); err != nil {
+		return err
+	}
+	if err := e.n.tr.Subscribe(root+e.path+"/+", messages); err != nil {
+		return err
+	}
+	time.AfterFunc(e.cfg.Settle, func() {
+		e.settled.Store(true)
+		e.evaluate()
+	})
+	go func() {
+		t := time.NewTicker(e.cfg.RenewInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-t.C:
+				if err := e.renew(); err != nil {
+					e.n.reportError(fmt.Errorf("election %s: error renewing claim: %w", e.cfg.Name, err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// IsLeader returns true if this node is the current leader
+func (e *LeaderElection) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Leader returns name and UUID of current leader, as seen by this node. Both are empty if there is none
+func (e *LeaderElection) Leader() (name string, uuid string) {
+	e.Lock()
+	defer e.Unlock()
+	return e.current.Name, e.current.UUID
+}
+
+// Resign withdraws candidacy and removes the claim. Election can't be restarted after that
+func (e *LeaderElection) Resign() error {
+	if !e.started.Load() {
+		return nil
+	}
+	e.Lock()
+	if e.stopped() {
+		e.Unlock()
+		return nil
+	}
+	close(e.stop)
+	e.current = electionCandidate{}
+	if e.leader.Swap(false) {
+		e.notifyTransition()
+	}
+	close(e.transitions)
+	e.Unlock()
+	return e.n.clearRetained(e.path + "/" + e.n.UUID)
+}
+
+func (e *LeaderElection) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// renew publishes our claim and re-evaluates, so expired candidates are dropped even without incoming messages
+func (e *LeaderElection) renew() error {
+	ev := e.n.NewEvent()
+	now := time.Now()
+	claim := electionClaim{Since: e.since.UnixNano(), Renewed: now.UnixNano(), Lease: e.cfg.Lease}
+	if err := ev.Marshal(claim); err != nil {
+		return err
+	}
+	ev.SetRetain(true)
+	err := e.n.SendEvent(e.path+"/"+e.n.UUID, ev)
+	e.Lock()
+	if e.stopped() {
+		e.Unlock()
+		return nil
+	}
+	if err == nil {
+		// do not wait for our own claim to come back from broker
+		e.candidates[e.n.UUID] = electionCandidate{
+			Name:    e.n.Name,
+			UUID:    e.n.UUID,
+			Since:   e.since,
+			Expires: now.Add(e.cfg.Lease),
+		}
+	}
+	e.Unlock()
+	e.evaluate()
+	return err
+}
+
+// receive handles discovery and claim messages. It keeps draining the channel after resign,
+// as transport has no way to unsubscribe
+func (e *LeaderElection) receive(messages chan *Message) {
+	claimPrefix := e.n.eventRoot + "/" + e.path + "/"
+	for m := range messages {
+		if e.stopped() {
+			continue
+		}
+		uuid := m.Topic[strings.LastIndex(m.Topic, "/")+1:]
+		if strings.HasPrefix(m.Topic, claimPrefix) {
+			e.handleClaim(uuid, m)
+		} else {
+			e.Lock()
+			if len(m.Payload) > 0 {
+				e.alive[uuid] = true
+			} else {
+				delete(e.alive, uuid)
+				if _, ok := e.candidates[uuid]; ok && uuid != e.n.UUID {
+					delete(e.candidates, uuid)
+					// dead node can't remove its own claim
+					go func() {
+						if err := e.n.clearRetained(e.path + "/" + uuid); err != nil {
+							e.n.l.Warnf("election %s: error removing claim of dead node %s: %s", e.cfg.Name, uuid, err)
+						}
+					}()
+				}
+			}
+			e.Unlock()
+		}
+		e.evaluate()
+	}
+}
+
+func (e *LeaderElection) handleClaim(uuid string, m *Message) {
+	if uuid == e.n.UUID {
+		// we track our own claim locally
+		return
+	}
+	if len(m.Payload) == 0 {
+		e.Lock()
+		delete(e.candidates, uuid)
+		e.Unlock()
+		return
+	}
+	ev, err := (&Event{}).Deserialize(m.Payload, e.n)
+	if err != nil {
+		e.n.l.Warnf("election %s: bad claim on [%s]: %s", e.cfg.Name, m.Topic, err)
+		return
+	}
+	if ev.NodeUUID != uuid {
+		e.n.l.Warnf("election %s: claim on [%s] sent by other node %s[%s]", e.cfg.Name, m.Topic, ev.NodeName, ev.NodeUUID)
+		return
+	}
+	var claim electionClaim
+	if err := ev.Unmarshal(&claim); err != nil {
+		e.n.l.Warnf("election %s: bad claim on [%s]: %s", e.cfg.Name, m.Topic, err)
+		return
+	}
+	e.Lock()
+	e.candidates[uuid] = electionCandidate{
+		Name:    ev.NodeName,
+		UUID:    uuid,
+		Since:   time.Unix(0, claim.Since),
+		Expires: time.Unix(0, claim.Renewed).Add(claim.Lease),
+	}
+	e.Unlock()
+}
+
+// evaluate picks the leader and queues callbacks if our leadership changed
+func (e *LeaderElection) evaluate() {
+	e.Lock()
+	defer e.Unlock()
+	if e.stopped() {
+		return
+	}
+	now := time.Now()
+	settled := e.settled.Load()
+	var best electionCandidate
+	for uuid, c := range e.candidates {
+		if now.After(c.Expires) {
+			continue
+		}
+		if uuid == e.n.UUID {
+			if !settled {
+				continue
+			}
+		} else if !e.alive[uuid] {
+			continue
+		}
+		if best.UUID == "" ||
+			c.Since.Before(best.Since) ||
+			(c.Since.Equal(best.Since) && c.UUID < best.UUID) {
+			best = c
+		}
+	}
+	e.current = best
+	isLeader := best.UUID == e.n.UUID
+	if e.leader.Swap(isLeader) != isLeader {
+		e.notifyTransition()
+	}
+}
+
+// notifyTransition wakes up callbacks() without waiting for them. Must be called with lock held
+func (e *LeaderElection) notifyTransition() {
+	select {
+	case e.transitions <- struct{}{}:
+	default:
+	}
+}
+
+// callbacks runs OnElected/OnDemoted when current leadership differs from the last reported one.
+// Changes that happen while callback is running collapse into the latest state
+func (e *LeaderElection) callbacks() {
+	reported := false
+	for range e.transitions {
+		elected := e.leader.Load()
+		if elected == reported {
+			continue
+		}
+		reported = elected
+		if elected {
+			e.n.l.Infof("election %s: elected", e.cfg.Name)
+			if e.cfg.OnElected != nil {
+				e.cfg.OnElected()
+			}
+		} else {
+			e.n.l.Infof("election %s: demoted", e.cfg.Name)
+			if e.cfg.OnDemoted != nil {
+				e.cfg.OnDemoted()
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package zerosvc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	broker := NewMemoryBroker()
+	type candidate struct {
+		tr      *TransportMemory
+		node    *Node
+		el      *LeaderElection
+		elected atomic.Int32
+		demoted atomic.Int32
+		uuid    string
+	}
+	newCandidate := func(name string) *candidate {
+		c := &candidate{}
+		var err error
+		c.tr, err = NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		c.node, err = NewNode(Config{NodeName: name, Transport: c.tr, EventRoot: "test"})
+		require.NoError(t, err)
+		c.uuid = c.node.UUID
+		c.el, err = NewLeaderElection(ElectionConfig{
+			Node:          c.node,
+			Name:          "bakery",
+			RenewInterval: time.Millisecond * 50,
+			Lease:         time.Millisecond * 200,
+			Settle:        time.Millisecond * 50,
+			OnElected:     func() { c.elected.Add(1) },
+			OnDemoted:     func() { c.demoted.Add(1) },
+		})
+		require.NoError(t, err)
+		require.NoError(t, c.el.Start())
+		return c
+	}
+	agreeOn := func(leader *candidate, all ...*candidate) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			for _, c := range all {
+				if _, uuid := c.el.Leader(); uuid != leader.uuid {
+					return false
+				}
+				if c.el.IsLeader() != (c == leader) {
+					return false
+				}
+			}
+			return true
+		}, time.Second*2, time.Millisecond*10)
+	}
+
+	a := newCandidate("a")
+	agreeOn(a, a)
+	b := newCandidate("b")
+	time.Sleep(time.Millisecond * 10)
+	c := newCandidate("c")
+	agreeOn(a, a, b, c)
+	assert.Equal(t, int32(1), a.elected.Load())
+	assert.Zero(t, b.elected.Load())
+	assert.Error(t, a.el.Start())
+
+	// will removes dead leader right away, dead leader demotes itself once its lease runs out
+	a.tr.Kill()
+	agreeOn(b, b, c)
+	require.Eventually(t, func() bool { return a.demoted.Load() == 1 }, time.Second, time.Millisecond*10)
+	assert.False(t, a.el.IsLeader())
+	require.Eventually(t, func() bool {
+		_, found := broker.Retained("test/election/bakery/" + a.uuid)
+		return !found
+	}, time.Second, time.Millisecond*10, "claim of dead node should be removed")
+
+	require.NoError(t, b.el.Resign())
+	require.NoError(t, b.el.Resign())
+	assert.False(t, b.el.IsLeader())
+	require.Eventually(t, func() bool { return b.demoted.Load() == 1 }, time.Second, time.Millisecond*10)
+	agreeOn(c, c)
+	_, found := broker.Retained("test/election/bakery/" + b.uuid)
+	assert.False(t, found)
+}
+
+func TestLeaderElectionTieBreak(t *testing.T) {
+	node := getTestDummyNode(t)
+	el, err := NewLeaderElection(ElectionConfig{Node: node, Name: "tie"})
+	require.NoError(t, err)
+	since := time.Now()
+	expires := since.Add(time.Hour)
+	el.settled.Store(true)
+	el.candidates = map[string]electionCandidate{
+		"bbb":     {Name: "b", UUID: "bbb", Since: since, Expires: expires},
+		"aaa":     {Name: "a", UUID: "aaa", Since: since, Expires: expires},
+		"000":     {Name: "dead", UUID: "000", Since: since, Expires: expires},
+		"expired": {Name: "old", UUID: "expired", Since: since.Add(-time.Hour), Expires: since},
+	}
+	el.alive = map[string]bool{"aaa": true, "bbb": true, "expired": true}
+	el.evaluate()
+	name, uuid := el.Leader()
+	assert.Equal(t, "a", name)
+	assert.Equal(t, "aaa", uuid)
+	assert.False(t, el.IsLeader())
+
+	_, err = NewLeaderElection(ElectionConfig{Node: node, Name: "bad/name"})
+	assert.Error(t, err)
+	_, err = NewLeaderElection(ElectionConfig{Node: node, Name: "lease", RenewInterval: time.Second, Lease: time.Second})
+	assert.Error(t, err)
+}
+
+func TestLeaderElectionSlowCallback(t *testing.T) {
+	release := make(chan bool)
+	var elected, demoted atomic.Int32
+	el, err := NewLeaderElection(ElectionConfig{
+		Node: getTestDummyNode(t),
+		Name: "slow",
+		OnElected: func() {
+			elected.Add(1)
+			<-release
+		},
+		OnDemoted: func() { demoted.Add(1) },
+	})
+	require.NoError(t, err)
+	go el.callbacks()
+	el.settled.Store(true)
+	self := electionCandidate{Name: el.n.Name, UUID: el.n.UUID, Since: time.Now(), Expires: time.Now().Add(time.Hour)}
+	el.candidates[self.UUID] = self
+	el.evaluate()
+	require.Eventually(t, func() bool { return elected.Load() == 1 }, time.Second, time.Millisecond)
+
+	// flapping while callback is stuck must not block evaluation
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			el.Lock()
+			delete(el.candidates, self.UUID)
+			el.Unlock()
+			el.evaluate()
+			el.Lock()
+			el.candidates[self.UUID] = self
+			el.Unlock()
+			el.evaluate()
+		}
+		el.Lock()
+		delete(el.candidates, self.UUID)
+		el.Unlock()
+		el.evaluate()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("evaluate blocked on slow callback")
+	}
+	close(release)
+	require.Eventually(t, func() bool { return demoted.Load() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), elected.Load(), "changes during slow callback should coalesce")
+}
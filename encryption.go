@@ -0,0 +1,334 @@
+package zerosvc
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"strings"
+)
+
+// GroupKeySize is required size of group (shared) encryption key
+const GroupKeySize = chacha20poly1305.KeySize
+
+const keyWrapInfo = "zerosvc e2e key wrap"
+
+// EncryptionInfo is attached to encrypted event and carries everything
+// recipient needs to decrypt Body and Headers, except its own key
+type EncryptionInfo struct {
+	// Ephemeral X25519 public key, present if any of the recipients is a node
+	Ephemeral []byte `cbor:"epk,omitempty" json:"epk,omitempty"`
+	// Nonce for content encryption
+	Nonce []byte `cbor:"n" json:"n"`
+	// Keys is content key wrapped for each of recipients
+	Keys []WrappedKey `cbor:"k" json:"k"`
+	// Compression of plaintext; encrypted events are compressed before encryption, as ciphertext does not compress
+	Compression Compression `cbor:"c,omitempty" json:"c,omitempty"`
+}
+
+type WrappedKey struct {
+	// ID is either fingerprint of recipient's X25519 key or name of the group key
+	ID    string `cbor:"id" json:"id"`
+	Group bool   `cbor:"g,omitempty" json:"g,omitempty"`
+	Key   []byte `cbor:"k" json:"k"`
+}
+
+// encryptedContent is plaintext of encrypted event
+type encryptedContent struct {
+	Headers map[string]any `cbor:"headers" json:"headers"`
+	Body    []byte         `cbor:"b" json:"b"`
+}
+
+type encryptionTargets struct {
+	nodes  []*ecdh.PublicKey
+	groups []string
+}
+
+// EncryptionKeyFromEd25519 derives X25519 key from Ed25519 private key, the same way as libsodium does.
+func EncryptionKeyFromEd25519(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("wrong private key size [%d:%d]", len(priv), ed25519.PrivateKeySize)
+	}
+	h := sha512.Sum512(priv.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// EncryptionKeyFingerprint returns short ID of X25519 public key, used to find recipient's key in the event
+func EncryptionKeyFingerprint(pub *ecdh.PublicKey) string {
+	h := sha256.Sum256(pub.Bytes())
+	return hex.EncodeToString(h[:8])
+}
+
+// RecipientFromNodeInfo gets encryption key of the node from its discovery data
+func RecipientFromNodeInfo(info NodeInfo) (*ecdh.PublicKey, error) {
+	if len(info.EncryptionKey) == 0 {
+		return nil, fmt.Errorf("node %s[%s] does not advertise encryption key", info.Name, info.UUID)
+	}
+	raw, err := base64.StdEncoding.DecodeString(info.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encryption key of %s: %w", info.Name, err)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// EncryptFor makes event encrypted for given recipients on serialization.
+// Can be called multiple times and combined with EncryptForGroup
+func (e *Event) EncryptFor(recipients ...*ecdh.PublicKey) {
+	if e.encryptTo == nil {
+		e.encryptTo = &encryptionTargets{}
+	}
+	e.encryptTo.nodes = append(e.encryptTo.nodes, recipients...)
+}
+
+// EncryptForGroup makes event encrypted with named group keys, previously added via Node.AddGroupKey
+func (e *Event) EncryptForGroup(keyIDs ...string) {
+	if e.encryptTo == nil {
+		e.encryptTo = &encryptionTargets{}
+	}
+	e.encryptTo.groups = append(e.encryptTo.groups, keyIDs...)
+}
+
+// AddGroupKey adds shared key used for fan-out topics; every member of the group needs the same key under the same ID
+func (n *Node) AddGroupKey(id string, key []byte) error {
+	if len(key) != GroupKeySize {
+		return fmt.Errorf("wrong group key size [%d:%d]", len(key), GroupKeySize)
+	}
+	if len(id) == 0 {
+		return fmt.Errorf("group key ID can't be empty")
+	}
+	n.Lock()
+	defer n.Unlock()
+	if n.groupKeys == nil {
+		n.groupKeys = map[string][]byte{}
+	}
+	n.groupKeys[id] = key
+	return nil
+}
+
+// EncryptTopic makes every event sent under pathPrefix (relative to event root) encrypted with group key,
+// unless the event has its own recipients set
+func (n *Node) EncryptTopic(pathPrefix string, keyID string) {
+	n.Lock()
+	defer n.Unlock()
+	if n.topicKeys == nil {
+		n.topicKeys = map[string]string{}
+	}
+	n.topicKeys[pathPrefix] = keyID
+}
+
+// EncryptionPublicKey returns node's X25519 public key, nil if node has no encryption key
+func (n *Node) EncryptionPublicKey() *ecdh.PublicKey {
+	k := n.encryptionKey()
+	if k == nil {
+		return nil
+	}
+	return k.PublicKey()
+}
+
+// encryptionKey returns configured key or one derived from Ed25519 signer
+func (n *Node) encryptionKey() *ecdh.PrivateKey {
+	if n.encKey != nil {
+		return n.encKey
+	}
+	if s, ok := n.Signer.(*SigEd25519); ok && len(s.Priv) == ed25519.PrivateKeySize {
+		k, err := EncryptionKeyFromEd25519(s.Priv)
+		if err == nil {
+			return k
+		}
+	}
+	return nil
+}
+
+func (n *Node) topicKeyFor(path string) (keyID string, found bool) {
+	n.RLock()
+	defer n.RUnlock()
+	longest := -1
+	for prefix, id := range n.topicKeys {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			keyID = id
+			longest = len(prefix)
+			found = true
+		}
+	}
+	return keyID, found
+}
+
+func deriveKEK(secret []byte, salt []byte) ([]byte, error) {
+	kek := make([]byte, chacha20poly1305.KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(keyWrapInfo)), kek)
+	return kek, err
+}
+
+func wrapKey(kek []byte, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+	// KEK is unique per event so static nonce is fine
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), key, nil), nil
+}
+
+func unwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+}
+
+// encrypt returns copy of event with Body and Headers replaced by ciphertext
+func (n *Node) encrypt(ev *Event) (*Event, error) {
+	plaintext, err := n.e.Marshal(encryptedContent{Headers: ev.Headers, Body: ev.Body})
+	if err != nil {
+		return nil, err
+	}
+	contentKey := rngBlob(chacha20poly1305.KeySize)
+	info := &EncryptionInfo{
+		Nonce: rngBlob(chacha20poly1305.NonceSizeX),
+	}
+	if c := n.compressionFor(ev, len(plaintext)); c != CompressionNone {
+		plaintext, err = compress(c, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		info.Compression = c
+	}
+	if len(ev.encryptTo.nodes) > 0 {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		info.Ephemeral = eph.PublicKey().Bytes()
+		for _, pub := range ev.encryptTo.nodes {
+			shared, err := eph.ECDH(pub)
+			if err != nil {
+				return nil, fmt.Errorf("error deriving shared key: %w", err)
+			}
+			kek, err := deriveKEK(shared, append(append([]byte{}, info.Nonce...), pub.Bytes()...))
+			if err != nil {
+				return nil, err
+			}
+			wrapped, err := wrapKey(kek, contentKey)
+			if err != nil {
+				return nil, err
+			}
+			info.Keys = append(info.Keys, WrappedKey{ID: EncryptionKeyFingerprint(pub), Key: wrapped})
+		}
+	}
+	for _, id := range ev.encryptTo.groups {
+		n.RLock()
+		groupKey, ok := n.groupKeys[id]
+		n.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("group key [%s] not found", id)
+		}
+		kek, err := deriveKEK(groupKey, info.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapKey(kek, contentKey)
+		if err != nil {
+			return nil, err
+		}
+		info.Keys = append(info.Keys, WrappedKey{ID: id, Group: true, Key: wrapped})
+	}
+	if len(info.Keys) == 0 {
+		return nil, fmt.Errorf("encryption requested but no recipients set")
+	}
+	aead, err := chacha20poly1305.NewX(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	out := *ev
+	out.Headers = nil
+	out.Body = aead.Seal(nil, info.Nonce, plaintext, []byte(ev.NodeUUID))
+	out.Encryption = info
+	return &out, nil
+}
+
+// decrypt replaces encrypted Body and Headers of the event with plaintext
+func (n *Node) decrypt(ev *Event) error {
+	info := ev.Encryption
+	if len(info.Nonce) != chacha20poly1305.NonceSizeX {
+		return fmt.Errorf("invalid encryption nonce")
+	}
+	var contentKey []byte
+	if key := n.encryptionKey(); key != nil && len(info.Ephemeral) > 0 {
+		fp := EncryptionKeyFingerprint(key.PublicKey())
+		for _, wk := range info.Keys {
+			if wk.Group || wk.ID != fp {
+				continue
+			}
+			eph, err := ecdh.X25519().NewPublicKey(info.Ephemeral)
+			if err != nil {
+				return fmt.Errorf("invalid ephemeral key: %w", err)
+			}
+			shared, err := key.ECDH(eph)
+			if err != nil {
+				return err
+			}
+			kek, err := deriveKEK(shared, append(append([]byte{}, info.Nonce...), key.PublicKey().Bytes()...))
+			if err != nil {
+				return err
+			}
+			contentKey, err = unwrapKey(kek, wk.Key)
+			if err != nil {
+				return fmt.Errorf("error unwrapping content key: %w", err)
+			}
+			break
+		}
+	}
+	if contentKey == nil {
+		n.RLock()
+		for _, wk := range info.Keys {
+			groupKey, ok := n.groupKeys[wk.ID]
+			if !wk.Group || !ok {
+				continue
+			}
+			kek, err := deriveKEK(groupKey, info.Nonce)
+			if err != nil {
+				n.RUnlock()
+				return err
+			}
+			contentKey, err = unwrapKey(kek, wk.Key)
+			if err != nil {
+				n.RUnlock()
+				return fmt.Errorf("error unwrapping content key: %w", err)
+			}
+			break
+		}
+		n.RUnlock()
+	}
+	if contentKey == nil {
+		return ErrNotRecipient{}
+	}
+	aead, err := chacha20poly1305.NewX(contentKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, info.Nonce, ev.Body, []byte(ev.NodeUUID))
+	if err != nil {
+		return fmt.Errorf("error decrypting event: %w", err)
+	}
+	if info.Compression != CompressionDefault && info.Compression != CompressionNone {
+		plaintext, err = decompress(info.Compression, plaintext, n.maxDecompressedSize)
+		if err != nil {
+			return err
+		}
+	}
+	var c encryptedContent
+	err = n.d.Unmarshal(plaintext, &c)
+	if err != nil {
+		return fmt.Errorf("error decoding decrypted event: %w", err)
+	}
+	ev.Headers = c.Headers
+	ev.Body = c.Body
+	return nil
+}
@@ -0,0 +1,117 @@
+package zerosvc
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	sender := getTestDummyNode(t, Config{NodeName: "sender", Signer: goneric.Must(NewSignerEd25519())})
+	receiver := getTestDummyNode(t, Config{NodeName: "receiver", Signer: goneric.Must(NewSignerEd25519())})
+	other := getTestDummyNode(t, Config{NodeName: "other", Signer: goneric.Must(NewSignerEd25519())})
+	for _, n := range []*Node{receiver, other} {
+		n.PubkeyRetriever = func(nodeName string, nodeUUID string) (v Verifier, found bool) {
+			return sender.Signer, nodeUUID == sender.UUID
+		}
+	}
+	groupKey := rngBlob(GroupKeySize)
+
+	t.Run("node recipient", func(t *testing.T) {
+		recipient, err := RecipientFromNodeInfo(NodeInfo{
+			Name:          receiver.Name,
+			EncryptionKey: base64.StdEncoding.EncodeToString(receiver.EncryptionPublicKey().Bytes()),
+		})
+		require.NoError(t, err)
+		ev := sender.NewEvent()
+		ev.Body = []byte("secret cake")
+		ev.Headers["recipe"] = "secret"
+		ev.EncryptFor(recipient)
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+		assert.Equal(t, []byte("secret cake"), ev.Body, "original event should not be modified")
+
+		out, err := (&Event{}).Deserialize(data, receiver)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret cake"), out.Body)
+		assert.Equal(t, "secret", out.Headers["recipe"])
+
+		_, err = (&Event{}).Deserialize(data, other)
+		assert.ErrorIs(t, err, ErrNotRecipient{})
+
+		// signature covers ciphertext
+		data[len(data)-3]++
+		_, err = (&Event{}).Deserialize(data, receiver)
+		assert.ErrorIs(t, err, ErrSignatureInvalid{})
+	})
+	t.Run("group key", func(t *testing.T) {
+		require.NoError(t, sender.AddGroupKey("cakes", groupKey))
+		require.NoError(t, other.AddGroupKey("cakes", groupKey))
+		sender.EncryptTopic("cakes/", "cakes")
+		keyID, found := sender.topicKeyFor("cakes/chocolate")
+		require.True(t, found)
+		assert.Equal(t, "cakes", keyID)
+		_, found = sender.topicKeyFor("pies/apple")
+		assert.False(t, found)
+
+		ev := sender.NewEvent()
+		ev.Body = []byte("group cake")
+		ev.EncryptForGroup(keyID)
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		out, err := (&Event{}).Deserialize(data, other)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("group cake"), out.Body)
+		_, err = (&Event{}).Deserialize(data, receiver)
+		assert.ErrorIs(t, err, ErrNotRecipient{})
+	})
+	t.Run("missing group key", func(t *testing.T) {
+		ev := sender.NewEvent()
+		ev.EncryptForGroup("pies")
+		_, err := ev.Serialize()
+		assert.ErrorContains(t, err, "pies")
+	})
+	t.Run("legacy envelope", func(t *testing.T) {
+		legacy := getTestDummyNode(t, Config{LegacyEnvelope: true})
+		ev := legacy.NewEvent()
+		ev.EncryptFor(receiver.EncryptionPublicKey())
+		_, err := ev.Serialize()
+		assert.Error(t, err)
+	})
+}
+
+func TestEncryptionCompression(t *testing.T) {
+	sender := getTestDummyNode(t, Config{NodeName: "sender", Compression: CompressionZstd, CompressionThreshold: 64})
+	receiver := getTestDummyNode(t, Config{NodeName: "receiver", EncryptionKey: goneric.Must(ecdh.X25519().GenerateKey(rand.Reader))})
+	ev := sender.NewEvent()
+	ev.Body = bytes.Repeat([]byte("cake "), 1000)
+	ev.EncryptFor(receiver.EncryptionPublicKey())
+	data, err := ev.Serialize()
+	require.NoError(t, err)
+	assert.Less(t, len(data), 1000, "plaintext should be compressed before encryption")
+	env, err := parseEnvelope(data)
+	require.NoError(t, err)
+	assert.Zero(t, env.Flags&EnvelopeFlagCompressed, "ciphertext should not be compressed again")
+	out, err := (&Event{}).Deserialize(data, receiver)
+	require.NoError(t, err)
+	assert.Equal(t, ev.Body, out.Body)
+	assert.Equal(t, CompressionZstd, out.Encryption.Compression)
+}
+
+func TestEncryptionKeyFromEd25519(t *testing.T) {
+	s, err := NewSignerEd25519()
+	require.NoError(t, err)
+	k1, err := EncryptionKeyFromEd25519(s.PrivateKey())
+	require.NoError(t, err)
+	k2, err := EncryptionKeyFromEd25519(s.PrivateKey())
+	require.NoError(t, err)
+	assert.Equal(t, k1.PublicKey().Bytes(), k2.PublicKey().Bytes())
+	_, err = EncryptionKeyFromEd25519([]byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package zerosvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Envelope versions
+const (
+	// EnvelopeV0 is legacy, unversioned framing:
+	//
+	//	<sig_length:uint8><signature:bytes><serialized event>
+	EnvelopeV0 uint8 = 0
+	// EnvelopeV1 adds magic, version and flags, and lifts 255 byte signature limit:
+	//
+	//	<magic:2 bytes><version:uint8><flags:uint8>[compression:uint8]<sig_length:uvarint><signature:bytes><serialized event>
+	//
+	// compression is only present if EnvelopeFlagCompressed is set.
+	// signature covers everything before sig_length together with serialized (and compressed) event
+	EnvelopeV1 uint8 = 1
+	// EnvelopeLatest is newest envelope version this library can decode
+	EnvelopeLatest = EnvelopeV1
+)
+
+// Envelope flags
+const (
+	EnvelopeFlagSigned     uint8 = 1 << 0
+	EnvelopeFlagCompressed uint8 = 1 << 1
+	EnvelopeFlagEncrypted  uint8 = 1 << 2
+)
+
+// envelopeKnownFlags is set of flags current version can handle
+const envelopeKnownFlags = EnvelopeFlagSigned | EnvelopeFlagCompressed | EnvelopeFlagEncrypted
+
+// envelopeMagic marks versioned envelope. V0 packet would need 254 byte signature starting with 'Z' to collide with it
+var envelopeMagic = []byte{0xfe, 'Z'}
+
+// maximum accepted signature length, mostly to not allocate absurd amounts on garbage input
+const envelopeMaxSigLength = 64 * 1024
+
+type envelope struct {
+	Version     uint8
+	Flags       uint8
+	Compression Compression
+	Signature   []byte
+	Payload     []byte
+}
+
+// header returns part of the envelope preceding signature; for V0 it is empty
+func (e *envelope) header() []byte {
+	if e.Version == EnvelopeV0 {
+		return []byte{}
+	}
+	h := make([]byte, 0, len(envelopeMagic)+3)
+	h = append(h, envelopeMagic...)
+	h = append(h, e.Version, e.Flags)
+	if e.Flags&EnvelopeFlagCompressed != 0 {
+		h = append(h, uint8(e.Compression))
+	}
+	return h
+}
+
+// signedData returns data that signature is supposed to cover
+func (e *envelope) signedData() []byte {
+	h := e.header()
+	if len(h) == 0 {
+		return e.Payload
+	}
+	return append(h, e.Payload...)
+}
+
+func (e *envelope) marshal() ([]byte, error) {
+	b := bytes.Buffer{}
+	switch e.Version {
+	case EnvelopeV0:
+		if len(e.Signature) > 255 {
+			return nil, fmt.Errorf("signature too long for v0 envelope [%d]", len(e.Signature))
+		}
+		if e.Flags&^EnvelopeFlagSigned != 0 {
+			return nil, fmt.Errorf("v0 envelope does not support flags [%08b]", e.Flags)
+		}
+		b.WriteByte(uint8(len(e.Signature)))
+	case EnvelopeV1:
+		b.Write(e.header())
+		b.Write(binary.AppendUvarint([]byte{}, uint64(len(e.Signature))))
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	b.Write(e.Signature)
+	b.Write(e.Payload)
+	return b.Bytes(), nil
+}
+
+func parseEnvelope(in []byte) (*envelope, error) {
+	if len(in) >= len(envelopeMagic) && bytes.Equal(in[:len(envelopeMagic)], envelopeMagic) {
+		return parseEnvelopeV1(in)
+	}
+	return parseEnvelopeV0(in)
+}
+
+func parseEnvelopeV0(in []byte) (*envelope, error) {
+	if len(in) < 4 {
+		return nil, fmt.Errorf("event data too short")
+	}
+	sigLength := int(in[0])
+	if len(in) < (4 + sigLength) {
+		return nil, fmt.Errorf("event data too short after signature[%d %d]", sigLength, len(in))
+	}
+	e := &envelope{
+		Version:   EnvelopeV0,
+		Signature: in[1 : sigLength+1],
+		Payload:   in[1+sigLength:],
+	}
+	if sigLength > 0 {
+		e.Flags |= EnvelopeFlagSigned
+	}
+	return e, nil
+}
+
+func parseEnvelopeV1(in []byte) (*envelope, error) {
+	hdrLen := len(envelopeMagic) + 2
+	if len(in) < hdrLen+1 {
+		return nil, fmt.Errorf("envelope header too short")
+	}
+	e := &envelope{
+		Version: in[len(envelopeMagic)],
+		Flags:   in[len(envelopeMagic)+1],
+	}
+	if e.Version != EnvelopeV1 {
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	if e.Flags&^envelopeKnownFlags != 0 {
+		return nil, fmt.Errorf("unsupported envelope flags [%08b]", e.Flags)
+	}
+	if e.Flags&EnvelopeFlagCompressed != 0 {
+		e.Compression = Compression(in[hdrLen])
+		hdrLen++
+	}
+	sigLength, n := binary.Uvarint(in[hdrLen:])
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid signature length")
+	}
+	if sigLength > envelopeMaxSigLength {
+		return nil, fmt.Errorf("signature too long [%d]", sigLength)
+	}
+	sigStart := hdrLen + n
+	if uint64(len(in)-sigStart) < sigLength {
+		return nil, fmt.Errorf("event data too short after signature[%d %d]", sigLength, len(in))
+	}
+	e.Signature = in[sigStart : sigStart+int(sigLength)]
+	e.Payload = in[sigStart+int(sigLength):]
+	if (len(e.Signature) > 0) != (e.Flags&EnvelopeFlagSigned != 0) {
+		return nil, fmt.Errorf("signed flag does not match signature presence")
+	}
+	return e, nil
+}
@@ -0,0 +1,113 @@
+package zerosvc
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func getTestDummyNode(t testing.TB, cfg ...Config) *Node {
+	tr, err := NewTransportDummy(ConfigDummy{})
+	require.NoError(t, err)
+	c := Config{}
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	if len(c.NodeName) == 0 {
+		c.NodeName = "node-" + t.Name()
+	}
+	c.Transport = tr
+	node, err := NewNode(c)
+	require.NoError(t, err)
+	return node
+}
+
+func TestEnvelope(t *testing.T) {
+	t.Run("v1 roundtrip", func(t *testing.T) {
+		e := envelope{
+			Version:   EnvelopeV1,
+			Flags:     EnvelopeFlagSigned,
+			Signature: bytes.Repeat([]byte{0xaa}, 600),
+			Payload:   []byte("payload"),
+		}
+		out, err := e.marshal()
+		require.NoError(t, err)
+		assert.Equal(t, envelopeMagic, out[:2])
+		parsed, err := parseEnvelope(out)
+		require.NoError(t, err)
+		assert.Equal(t, e, *parsed)
+	})
+	t.Run("v0 roundtrip", func(t *testing.T) {
+		e := envelope{
+			Version:   EnvelopeV0,
+			Flags:     EnvelopeFlagSigned,
+			Signature: bytes.Repeat([]byte{0xaa}, 64),
+			Payload:   []byte("payload"),
+		}
+		out, err := e.marshal()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(64), out[0])
+		parsed, err := parseEnvelope(out)
+		require.NoError(t, err)
+		assert.Equal(t, e, *parsed)
+	})
+	t.Run("v0 long signature", func(t *testing.T) {
+		e := envelope{
+			Version:   EnvelopeV0,
+			Signature: make([]byte, 512),
+		}
+		_, err := e.marshal()
+		assert.Error(t, err)
+	})
+	t.Run("unknown version", func(t *testing.T) {
+		_, err := parseEnvelope(append(envelopeMagic, 99, 0, 0, 1, 2, 3))
+		assert.ErrorContains(t, err, "version")
+	})
+	t.Run("unknown flags", func(t *testing.T) {
+		_, err := parseEnvelope(append(envelopeMagic, EnvelopeV1, 0x80, 0, 1, 2, 3))
+		assert.ErrorContains(t, err, "flags")
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := parseEnvelope(append(envelopeMagic, EnvelopeV1, EnvelopeFlagSigned, 64, 1, 2, 3))
+		assert.Error(t, err)
+	})
+}
+
+func TestEventSerialize(t *testing.T) {
+	signer, err := NewSignerEd25519()
+	require.NoError(t, err)
+	for _, legacy := range []bool{false, true} {
+		node := getTestDummyNode(t, Config{LegacyEnvelope: legacy, Signer: signer})
+		ev := node.NewEvent()
+		ev.Body = []byte("cake")
+		ev.Headers["flavour"] = "chocolate"
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		if legacy {
+			assert.Equal(t, uint8(64), data[0])
+		} else {
+			assert.Equal(t, envelopeMagic, data[:2])
+		}
+		out, err := (&Event{}).Deserialize(data, node)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("cake"), out.Body)
+		assert.Equal(t, "chocolate", out.Headers["flavour"])
+		assert.Len(t, out.Signature, 64)
+
+		data[len(data)-1]++
+		_, err = (&Event{}).Deserialize(data, node)
+		assert.ErrorIs(t, err, ErrSignatureInvalid{})
+	}
+	t.Run("unsigned", func(t *testing.T) {
+		node := getTestDummyNode(t)
+		ev := node.NewEvent()
+		ev.Body = []byte("cake")
+		data, err := ev.Serialize()
+		require.NoError(t, err)
+		out, err := (&Event{}).Deserialize(data, node)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("cake"), out.Body)
+		assert.Empty(t, out.Signature)
+	})
+}
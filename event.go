@@ -1,55 +1,96 @@
 package zerosvc
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/fxamacker/cbor/v2"
 )
 
-// Serialize serializes event into binary blob. Format:
+// Serialize serializes event into binary blob, framed in envelope version selected by the node.
+// See EnvelopeV0 and EnvelopeV1 for the format.
 //
-//	<sig_length:uint8><signature:bytes><serialized event>
-//
-// If signing is disabled packet starts with 0x00 and event immediately after.
+// If signing is disabled the signature is empty. If event is encrypted, signature covers the ciphertext
+// and compression is applied to the plaintext, inside encryption.
 func (e *Event) Serialize() (out []byte, err error) {
-	data, err := e.n.e.Marshal(e)
+	toMarshal := e
+	if e.encryptTo != nil {
+		toMarshal, err = e.n.encrypt(e)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting event: %w", err)
+		}
+	}
+	data, err := e.n.e.Marshal(toMarshal)
 	if err != nil {
 		return
 	}
-	sigLength := uint8(0)
-	signature := []byte{}
+	env := envelope{
+		Version: e.n.EnvelopeVersion(),
+		Payload: data,
+	}
+	if toMarshal.Encryption != nil {
+		if env.Version == EnvelopeV0 {
+			return nil, fmt.Errorf("encryption is not supported with legacy envelope")
+		}
+		env.Flags |= EnvelopeFlagEncrypted
+	}
+	// encrypted content is compressed before encryption
+	if c := e.n.compressionFor(e, len(data)); c != CompressionNone && toMarshal.Encryption == nil {
+		if env.Version == EnvelopeV0 {
+			return nil, fmt.Errorf("compression is not supported with legacy envelope")
+		}
+		env.Payload, err = compress(c, data)
+		if err != nil {
+			return nil, err
+		}
+		env.Flags |= EnvelopeFlagCompressed
+		env.Compression = c
+	}
 	if e.n.Signer != nil {
-		signature := e.n.Signer.Sign(out)
-		sigLength = uint8(len(signature))
-		if sigLength < 8 {
+		env.Flags |= EnvelopeFlagSigned
+		env.Signature = e.n.Signer.Sign(env.signedData())
+		if len(env.Signature) < 8 {
 			return out, fmt.Errorf("signing function defined but signature is empty")
 		}
 	}
-	b := bytes.Buffer{}
-	b.WriteByte(sigLength)
-	b.Write(signature)
-	b.Write(data)
-	return b.Bytes(), nil
+	return env.marshal()
 }
 
+// Deserialize decodes event from any supported envelope version and verifies signature if present
 func (e *Event) Deserialize(in []byte, node *Node) (ev *Event, err error) {
-	if len(in) < 4 {
-		return nil, fmt.Errorf("event data too short")
-	}
-	sigLength := uint8(in[0])
-	if len(in) < (4 + int(sigLength)) {
-		return nil, fmt.Errorf("event data too short after signature[%d %d]", sigLength, len(in))
+	env, err := parseEnvelope(in)
+	if err != nil {
+		return nil, err
 	}
-	signature := in[1 : sigLength+1]
-	data := in[1+sigLength:]
-	if sigLength > 0 {
-		valid := node.Signer.Verify(signature, data)
-		if !valid {
-			return nil, ErrSignatureInvalid{}
+	payload := env.Payload
+	if env.Flags&EnvelopeFlagCompressed != 0 {
+		payload, err = decompress(env.Compression, env.Payload, node.maxDecompressedSize)
+		if err != nil {
+			return nil, err
 		}
 	}
 	ev = &Event{}
-	err = node.d.Unmarshal(in[sigLength+1:], ev)
+	err = node.d.Unmarshal(payload, ev)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Signature) > 0 {
+		if v := node.verifierFor(ev); v != nil {
+			if !v.Verify(env.signedData(), env.Signature) {
+				return nil, ErrSignatureInvalid{}
+			}
+			ev.Verified = true
+		}
+		ev.Signature = env.Signature
+	}
+	if (env.Flags&EnvelopeFlagEncrypted != 0) != (ev.Encryption != nil) {
+		return nil, fmt.Errorf("encrypted flag does not match event content")
+	}
+	if ev.Encryption != nil {
+		err = node.decrypt(ev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ev.n = node
 	return ev, err
 }
 
@@ -65,3 +106,8 @@ func (e *Event) Unmarshal(v interface{}) error {
 	return cbor.Unmarshal(e.Body, v)
 
 }
+
+// SetRetain makes the broker keep the event as last one on its topic and deliver it to new subscribers
+func (e *Event) SetRetain(retain bool) {
+	e.retain = retain
+}
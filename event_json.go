@@ -0,0 +1,141 @@
+package zerosvc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// EventJSON is JSON representation of event, used by gateways to JSON based protocols.
+//
+// Body that is valid JSON is embedded as-is, other UTF-8 text as JSON string and binary as base64 in BodyB64.
+// When building event, JSON string body is used as text and any other JSON value as raw JSON
+type EventJSON struct {
+	Topic       string          `json:"topic,omitempty"`
+	Node        string          `json:"node,omitempty"`
+	UUID        string          `json:"uuid,omitempty"`
+	TS          *time.Time      `json:"ts,omitempty"`
+	TraceID     string          `json:"trace_id,omitempty"`
+	SpanID      string          `json:"span_id,omitempty"`
+	ReplyTo     string          `json:"reply_to,omitempty"`
+	Headers     map[string]any  `json:"headers,omitempty"`
+	Signed      bool            `json:"signed,omitempty"`
+	Verified    bool            `json:"verified,omitempty"`
+	Redelivered bool            `json:"redelivered,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	BodyB64     []byte          `json:"body_b64,omitempty"`
+}
+
+func NewEventJSON(ev *Event) EventJSON {
+	out := EventJSON{
+		Topic:       ev.Topic,
+		Node:        ev.NodeName,
+		UUID:        ev.NodeUUID,
+		TraceID:     hex.EncodeToString(ev.TraceID),
+		SpanID:      hex.EncodeToString(ev.SpanID),
+		ReplyTo:     ev.ReplyTo,
+		Signed:      len(ev.Signature) > 0,
+		Verified:    ev.Verified,
+		Redelivered: ev.Redelivered,
+	}
+	if !ev.TS.IsZero() {
+		ts := ev.TS
+		out.TS = &ts
+	}
+	if len(ev.Headers) > 0 {
+		out.Headers = jsonSafeValue(ev.Headers).(map[string]any)
+	}
+	switch {
+	case len(ev.Body) == 0:
+	case json.Valid(ev.Body):
+		out.Body = ev.Body
+	case utf8.Valid(ev.Body):
+		out.Body, _ = json.Marshal(string(ev.Body))
+	default:
+		out.BodyB64 = ev.Body
+	}
+	return out
+}
+
+// Event builds new event of the node from JSON. Only TraceID, ReplyTo, Headers and body are used,
+// rest is set by the node. json.Number header values are converted to int64 or float64
+func (j *EventJSON) Event(n *Node) (ev Event, err error) {
+	if len(j.TraceID) > 0 {
+		traceID, err := hex.DecodeString(j.TraceID)
+		if err != nil {
+			return ev, fmt.Errorf("error decoding trace_id: %w", err)
+		}
+		ev = n.NewEvent(traceID)
+	} else {
+		ev = n.NewEvent()
+	}
+	ev.ReplyTo = j.ReplyTo
+	for k, v := range j.Headers {
+		ev.Headers[k] = fromJSONNumber(v)
+	}
+	var s string
+	switch {
+	case len(j.BodyB64) > 0:
+		if len(j.Body) > 0 {
+			return ev, fmt.Errorf("only one of body and body_b64 can be set")
+		}
+		ev.Body = j.BodyB64
+	case len(j.Body) == 0 || string(j.Body) == "null":
+	case json.Unmarshal(j.Body, &s) == nil:
+		ev.Body = []byte(s)
+	default:
+		ev.Body = j.Body
+	}
+	return ev, nil
+}
+
+// jsonSafeValue converts values CBOR decoder produces and JSON can't encode, like maps with non-string keys
+func jsonSafeValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[k] = jsonSafeValue(e)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[fmt.Sprint(k)] = jsonSafeValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, e := range t {
+			out[i] = jsonSafeValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func fromJSONNumber(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = fromJSONNumber(e)
+		}
+		return t
+	case []any:
+		for i, e := range t {
+			t[i] = fromJSONNumber(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
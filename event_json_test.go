@@ -0,0 +1,57 @@
+package zerosvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventJSON(t *testing.T) {
+	n := getTestDummyNode(t, Config{NodeName: "test"})
+
+	var in EventJSON
+	dec := json.NewDecoder(bytes.NewReader([]byte(`{"trace_id":"0102","headers":{"n":3,"f":1.5},"body":{"kind":"cheese"}}`)))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&in))
+	ev, err := in.Event(n)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, ev.TraceID)
+	assert.Equal(t, int64(3), ev.Headers["n"])
+	assert.Equal(t, 1.5, ev.Headers["f"])
+	assert.JSONEq(t, `{"kind":"cheese"}`, string(ev.Body))
+	assert.Equal(t, "test", ev.NodeName)
+
+	for _, c := range []struct {
+		in   string
+		body []byte
+	}{
+		{`{"body":"text"}`, []byte("text")},
+		{`{"body_b64":"AAE="}`, []byte{0, 1}},
+		{`{}`, nil},
+	} {
+		var in EventJSON
+		require.NoError(t, json.Unmarshal([]byte(c.in), &in))
+		ev, err := in.Event(n)
+		require.NoError(t, err, c.in)
+		assert.Equal(t, c.body, ev.Body, c.in)
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"body":"a","body_b64":"AAE="}`), &in))
+	_, err = in.Event(n)
+	assert.Error(t, err)
+
+	ev = n.NewEvent()
+	ev.Headers["m"] = map[any]any{1: "one"}
+	ev.Body = []byte("plain")
+	out := NewEventJSON(&ev)
+	assert.Equal(t, `"plain"`, string(out.Body))
+	assert.Equal(t, map[string]any{"1": "one"}, out.Headers["m"])
+	_, err = json.Marshal(out)
+	require.NoError(t, err)
+	ev.Body = []byte{0xff, 0}
+	out = NewEventJSON(&ev)
+	assert.Empty(t, out.Body)
+	assert.Equal(t, []byte{0xff, 0}, out.BodyB64)
+}
@@ -0,0 +1,412 @@
+// Package httpgw exposes zerosvc events over HTTP, for clients that can't speak MQTT.
+//
+//	POST /events/{path...}     publish event (EventJSON body) to path under event root; ?retain=true to retain
+//	POST /call/{path...}       send request and wait for replies; ?timeout=5s&count=1, count=0 collects all until timeout
+//	GET  /nodes                nodes present in discovery
+//	GET  /subscribe/{filter...} Server-Sent Events stream of decoded events; "#" has to be URL-encoded as %23
+//	GET  /ws                   WebSocket with subscribe/publish protocol, see wsRequest; only if WebSocketPolicy is set
+package httpgw
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+	"go.uber.org/zap"
+)
+
+// Action is type of operation passed to Auth
+type Action string
+
+const (
+	ActionPublish   Action = "publish"
+	ActionCall      Action = "call"
+	ActionNodes     Action = "nodes"
+	ActionSubscribe Action = "subscribe"
+)
+
+// ErrUnauthenticated returned from Auth makes gateway respond with 401 instead of 403
+var ErrUnauthenticated = errors.New("authentication required")
+
+type Config struct {
+	Node *zerosvc.Node
+	// Discovery used by /nodes, created from Node if nil
+	Discovery *zerosvc.Discovery
+	// Auth is called for every request with action and event path (or filter for subscribe, empty for nodes).
+	// Returning error denies the request. nil allows everything
+	Auth func(r *http.Request, action Action, path string) error
+	// CallTimeout is default time to wait for replies, 5s if 0
+	CallTimeout time.Duration
+	// MaxCallTimeout caps timeout requested by client, 1m if 0
+	MaxCallTimeout time.Duration
+	// MaxBodySize of request body, 1MB if 0
+	MaxBodySize int64
+	// KeepAlive is interval of SSE keepalive comments and websocket pings, 15s if 0
+	KeepAlive time.Duration
+	// MaxStreams caps number of distinct filters streamed to /subscribe and /ws clients. Transport can't unsubscribe,
+	// so subscription of each filter is kept for gateway's lifetime. DefaultMaxStreams if 0
+	MaxStreams int
+	// WebSocketPolicy returns policy of connecting websocket client; error rejects connection.
+	// Auth is not used for websocket. /ws endpoint is disabled if nil
+	WebSocketPolicy func(r *http.Request) (Policy, error)
+	// CheckOrigin of websocket connections, same-origin only if nil
+	CheckOrigin func(r *http.Request) bool
+	Logger      *zap.SugaredLogger
+}
+
+type Gateway struct {
+	cfg     Config
+	mux     *http.ServeMux
+	replyTo string
+	pending map[string]chan zerosvc.Event
+	streams map[string]*stream
+	l       *zap.SugaredLogger
+	sync.Mutex
+}
+
+func New(cfg Config) (*Gateway, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if cfg.CallTimeout <= 0 {
+		cfg.CallTimeout = time.Second * 5
+	}
+	if cfg.MaxCallTimeout <= 0 {
+		cfg.MaxCallTimeout = time.Minute
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = 1024 * 1024
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = time.Second * 15
+	}
+	if cfg.MaxStreams <= 0 {
+		cfg.MaxStreams = DefaultMaxStreams
+	}
+	if cfg.Discovery == nil {
+		d, err := zerosvc.NewDiscovery(zerosvc.DiscoveryConfig{Node: cfg.Node})
+		if err != nil {
+			return nil, fmt.Errorf("error setting up discovery: %w", err)
+		}
+		cfg.Discovery = d
+	}
+	g := &Gateway{
+		cfg:     cfg,
+		mux:     http.NewServeMux(),
+		pending: map[string]chan zerosvc.Event{},
+		streams: map[string]*stream{},
+		l:       cfg.Logger,
+	}
+	if g.l == nil {
+		g.l = zap.NewNop().Sugar()
+	}
+	replyTo, replies, err := cfg.Node.GetReplyChan()
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing to replies: %w", err)
+	}
+	g.replyTo = replyTo
+	go g.dispatchReplies(replies)
+	g.mux.HandleFunc("POST /events/{path...}", g.handlePublish)
+	g.mux.HandleFunc("POST /call/{path...}", g.handleCall)
+	g.mux.HandleFunc("GET /nodes", g.handleNodes)
+	g.mux.HandleFunc("GET /subscribe/{filter...}", g.handleSubscribe)
+	if cfg.WebSocketPolicy != nil {
+		g.mux.HandleFunc("GET /ws", g.handleWebSocket)
+	}
+	return g, nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// BearerAuth returns Auth function accepting any of the tokens in "Authorization: Bearer" header
+func BearerAuth(tokens ...string) func(r *http.Request, action Action, path string) error {
+	valid := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		valid[t] = true
+	}
+	return func(r *http.Request, action Action, path string) error {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return ErrUnauthenticated
+		}
+		if !valid[token] {
+			return fmt.Errorf("invalid token")
+		}
+		return nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// authorize runs Auth and writes error response if request is denied
+func (g *Gateway) authorize(w http.ResponseWriter, r *http.Request, action Action, path string) bool {
+	if g.cfg.Auth == nil {
+		return true
+	}
+	err := g.cfg.Auth(r, action, path)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrUnauthenticated):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err)
+	default:
+		writeError(w, http.StatusForbidden, err)
+	}
+	return false
+}
+
+// eventPath returns path from request, rejecting wildcards and empty segments
+func eventPath(r *http.Request) (string, error) {
+	path := r.PathValue("path")
+	if len(path) == 0 {
+		return "", fmt.Errorf("path required")
+	}
+	if strings.ContainsAny(path, "+#") || strings.Contains(path, "//") || strings.HasSuffix(path, "/") {
+		return "", fmt.Errorf("invalid path [%s]", path)
+	}
+	return path, nil
+}
+
+// readEvent decodes EventJSON from request body
+func (g *Gateway) readEvent(w http.ResponseWriter, r *http.Request) (ev zerosvc.Event, err error) {
+	var in zerosvc.EventJSON
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, g.cfg.MaxBodySize))
+	dec.UseNumber()
+	if err := dec.Decode(&in); err != nil {
+		return ev, fmt.Errorf("error decoding event: %w", err)
+	}
+	return in.Event(g.cfg.Node)
+}
+
+func (g *Gateway) handlePublish(w http.ResponseWriter, r *http.Request) {
+	path, err := eventPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !g.authorize(w, r, ActionPublish, path) {
+		return
+	}
+	ev, err := g.readEvent(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// whoever answers publishes to reply_to on behalf of the client, so it needs the same permission
+	if len(ev.ReplyTo) > 0 {
+		replyPath, found := strings.CutPrefix(ev.ReplyTo, g.cfg.Node.EventRoot()+"/")
+		if !found || strings.ContainsAny(replyPath, "+#") {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("reply_to [%s] not allowed", ev.ReplyTo))
+			return
+		}
+		if !g.authorize(w, r, ActionPublish, replyPath) {
+			return
+		}
+	}
+	if retain, _ := strconv.ParseBool(r.URL.Query().Get("retain")); retain {
+		ev.SetRetain(true)
+	}
+	if err := g.cfg.Node.SendEvent(path, ev); err != nil {
+		g.l.Errorf("error publishing to [%s]: %s", path, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"trace_id": hex.EncodeToString(ev.TraceID),
+		"span_id":  hex.EncodeToString(ev.SpanID),
+	})
+}
+
+// callReply is reply with time it took since request was sent
+type callReply struct {
+	zerosvc.EventJSON
+	Latency string `json:"latency"`
+}
+
+// dispatchReplies routes replies to pending calls by trace ID, which PrepareReply copies from request
+func (g *Gateway) dispatchReplies(replies chan zerosvc.Event) {
+	for ev := range replies {
+		g.Lock()
+		ch, ok := g.pending[string(ev.TraceID)]
+		g.Unlock()
+		if !ok {
+			g.l.Debugf("reply from %s[%s] to unknown or expired call", ev.NodeName, ev.NodeUUID)
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+			g.l.Debugf("dropping reply from %s[%s], call already has enough replies", ev.NodeName, ev.NodeUUID)
+		}
+	}
+}
+
+func (g *Gateway) handleCall(w http.ResponseWriter, r *http.Request) {
+	path, err := eventPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !g.authorize(w, r, ActionCall, path) {
+		return
+	}
+	q := r.URL.Query()
+	timeout := g.cfg.CallTimeout
+	if s := q.Get("timeout"); len(s) > 0 {
+		timeout, err = time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout [%s]", s))
+			return
+		}
+	}
+	timeout = min(timeout, g.cfg.MaxCallTimeout)
+	count := 1
+	if s := q.Get("count"); len(s) > 0 {
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count [%s]", s))
+			return
+		}
+	}
+	ev, err := g.readEvent(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(ev.TraceID) == 0 {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("node has tracing disabled, can't match replies"))
+		return
+	}
+	ev.ReplyTo = g.replyTo
+	buf := count
+	if buf == 0 {
+		buf = 256
+	}
+	ch := make(chan zerosvc.Event, buf)
+	key := string(ev.TraceID)
+	g.Lock()
+	if _, busy := g.pending[key]; busy {
+		g.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("call with trace_id %x already in progress", ev.TraceID))
+		return
+	}
+	g.pending[key] = ch
+	g.Unlock()
+	defer func() {
+		g.Lock()
+		delete(g.pending, key)
+		g.Unlock()
+	}()
+
+	sent := time.Now()
+	if err := g.cfg.Node.SendEvent(path, ev); err != nil {
+		g.l.Errorf("error sending call to [%s]: %s", path, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	replies := []callReply{}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+collect:
+	for count == 0 || len(replies) < count {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			break collect
+		case reply := <-ch:
+			replies = append(replies, callReply{
+				EventJSON: zerosvc.NewEventJSON(&reply),
+				Latency:   time.Since(sent).String(),
+			})
+		}
+	}
+	if len(replies) == 0 {
+		writeError(w, http.StatusGatewayTimeout, fmt.Errorf("no replies within %s", timeout))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"replies": replies})
+}
+
+func (g *Gateway) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if !g.authorize(w, r, ActionNodes, "") {
+		return
+	}
+	writeJSON(w, http.StatusOK, g.cfg.Discovery.Nodes())
+}
+
+func (g *Gateway) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	filter := r.PathValue("filter")
+	if err := validFilter(filter); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !g.authorize(w, r, ActionSubscribe, filter) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	s, err := g.stream(filter)
+	switch {
+	case errors.Is(err, ErrTooManyStreams):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	c := s.add()
+	defer s.remove(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepalive := time.NewTicker(g.cfg.KeepAlive)
+	defer keepalive.Stop()
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case ev := <-c:
+			data, err := json.Marshal(zerosvc.NewEventJSON(&ev))
+			if err != nil {
+				g.l.Errorf("error encoding event from [%s]: %s", ev.Topic, err)
+				continue
+			}
+			buf.Reset()
+			fmt.Fprintf(&buf, "event: event\ndata: %s\n\n", data)
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
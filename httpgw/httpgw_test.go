@@ -0,0 +1,198 @@
+package httpgw
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+func testNode(t *testing.T, broker *zerosvc.MemoryBroker, name string) *zerosvc.Node {
+	tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	n, err := zerosvc.NewNode(zerosvc.Config{NodeName: name, Transport: tr, EventRoot: "test"})
+	require.NoError(t, err)
+	return n
+}
+
+func testGateway(t *testing.T, cfg Config) (*httptest.Server, *zerosvc.MemoryBroker) {
+	broker := zerosvc.NewMemoryBroker()
+	cfg.Node = testNode(t, broker, "gw")
+	g, err := New(cfg)
+	require.NoError(t, err)
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return srv, broker
+}
+
+func do(t *testing.T, method string, url string, body string, token ...string) (int, map[string]any) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token[0])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	out := map[string]any{}
+	if len(data) > 0 && data[0] == '{' {
+		require.NoError(t, json.Unmarshal(data, &out), string(data))
+	}
+	return resp.StatusCode, out
+}
+
+func TestPublish(t *testing.T) {
+	srv, broker := testGateway(t, Config{})
+	n := testNode(t, broker, "eater")
+	events, err := n.GetEventsCh("cakes/#")
+	require.NoError(t, err)
+
+	status, out := do(t, "POST", srv.URL+"/events/cakes/cheese", `{"headers":{"size":3},"body":{"kind":"cheese"}}`)
+	require.Equal(t, http.StatusAccepted, status, out)
+	assert.NotEmpty(t, out["trace_id"])
+	got := goneric.ChanToSliceNTimeout(events, 1, time.Second)
+	require.Len(t, got, 1)
+	assert.Equal(t, "gw", got[0].NodeName)
+	assert.Equal(t, uint64(3), got[0].Headers["size"])
+	assert.JSONEq(t, `{"kind":"cheese"}`, string(got[0].Body))
+
+	status, _ = do(t, "POST", srv.URL+"/events/cakes/%23", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status, "wildcards can't be published to")
+	status, _ = do(t, "POST", srv.URL+"/events/cakes/x", `not json`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestCall(t *testing.T) {
+	srv, broker := testGateway(t, Config{})
+	for _, name := range []string{"oven-1", "oven-2"} {
+		n := testNode(t, broker, name)
+		requests, err := n.GetEventsCh("ovens/temp")
+		require.NoError(t, err)
+		go func() {
+			for req := range requests {
+				reply := n.PrepareReply(req)
+				reply.Body = []byte(`{"temp":180}`)
+				_ = n.SendEvent(strings.TrimPrefix(req.ReplyTo, "test/"), reply)
+			}
+		}()
+	}
+	status, out := do(t, "POST", srv.URL+"/call/ovens/temp", `{"body":"?"}`)
+	require.Equal(t, http.StatusOK, status, out)
+	replies := out["replies"].([]any)
+	require.Len(t, replies, 1)
+	reply := replies[0].(map[string]any)
+	assert.Equal(t, map[string]any{"temp": float64(180)}, reply["body"])
+	assert.NotEmpty(t, reply["latency"])
+
+	status, out = do(t, "POST", srv.URL+"/call/ovens/temp?count=0&timeout=200ms", `{}`)
+	require.Equal(t, http.StatusOK, status, out)
+	assert.Len(t, out["replies"], 2)
+
+	status, _ = do(t, "POST", srv.URL+"/call/fridges/temp?timeout=50ms", `{}`)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	status, _ = do(t, "POST", srv.URL+"/call/ovens/temp?timeout=never", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestNodes(t *testing.T) {
+	srv, broker := testGateway(t, Config{})
+	testNode(t, broker, "oven")
+	var nodes []zerosvc.NodeInfo
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(srv.URL + "/nodes")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&nodes))
+		return len(nodes) == 2
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, "gw", nodes[0].Name)
+	assert.Equal(t, "oven", nodes[1].Name)
+}
+
+func TestSubscribe(t *testing.T) {
+	srv, broker := testGateway(t, Config{})
+	n := testNode(t, broker, "baker")
+	read := func() (*bufio.Reader, func()) {
+		resp, err := http.Get(srv.URL + "/subscribe/cakes/%23")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	r1, close1 := read()
+	defer close1()
+	r2, close2 := read()
+	defer close2()
+
+	ev := n.NewEvent()
+	ev.Body = []byte("cheesecake")
+	require.NoError(t, n.SendEvent("cakes/cheese", ev))
+	for _, r := range []*bufio.Reader{r1, r2} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "event: event\n", line)
+		line, err = r.ReadString('\n')
+		require.NoError(t, err)
+		var out zerosvc.EventJSON
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &out))
+		assert.Equal(t, "test/cakes/cheese", out.Topic)
+		assert.Equal(t, "baker", out.Node)
+		assert.Equal(t, `"cheesecake"`, string(out.Body))
+	}
+}
+
+func TestSubscribeLimits(t *testing.T) {
+	srv, _ := testGateway(t, Config{MaxStreams: 1})
+	get := func(filter string) int {
+		resp, err := http.Get(srv.URL + "/subscribe/" + filter)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("cakes/%23"))
+	assert.Equal(t, http.StatusOK, get("cakes/%23"), "same filter should reuse stream")
+	assert.Equal(t, http.StatusServiceUnavailable, get("pies/%23"))
+	assert.Equal(t, http.StatusBadRequest, get("cakes/%23/cheese"))
+	assert.Equal(t, http.StatusBadRequest, get("cakes/che%2B"))
+}
+
+func TestAuth(t *testing.T) {
+	bearer := BearerAuth("secret")
+	srv, _ := testGateway(t, Config{Auth: func(r *http.Request, action Action, path string) error {
+		if err := bearer(r, action, path); err != nil {
+			return err
+		}
+		if action == ActionPublish && !strings.HasPrefix(path, "public/") {
+			return io.ErrClosedPipe
+		}
+		return nil
+	}})
+	status, _ := do(t, "POST", srv.URL+"/events/public/x", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{}`, "wrong")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = do(t, "POST", srv.URL+"/events/private/x", `{}`, "secret")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{}`, "secret")
+	assert.Equal(t, http.StatusAccepted, status)
+
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{"reply_to":"test/private/x"}`, "secret")
+	assert.Equal(t, http.StatusForbidden, status, "reply_to needs publish permission")
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{"reply_to":"other/public/x"}`, "secret")
+	assert.Equal(t, http.StatusBadRequest, status, "reply_to outside of event root")
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{"reply_to":"test/public/#"}`, "secret")
+	assert.Equal(t, http.StatusBadRequest, status, "reply_to with wildcard")
+	status, _ = do(t, "POST", srv.URL+"/events/public/x", `{"reply_to":"test/public/y"}`, "secret")
+	assert.Equal(t, http.StatusAccepted, status)
+}
@@ -0,0 +1,93 @@
+package httpgw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// clientBuffer is number of events buffered per streaming client; events for slower clients are dropped
+const clientBuffer = 64
+
+// DefaultMaxStreams is number of distinct filters gateway streams if Config.MaxStreams is not set
+const DefaultMaxStreams = 256
+
+// ErrTooManyStreams is returned when subscribing to a new filter after Config.MaxStreams was reached
+var ErrTooManyStreams = errors.New("too many distinct subscriptions")
+
+// stream fans out events of one subscription to all clients streaming that filter.
+// Transport can't unsubscribe, so subscription is shared and kept for gateway's lifetime
+type stream struct {
+	clients map[chan zerosvc.Event]struct{}
+	sync.Mutex
+}
+
+// stream returns stream for the filter, subscribing on first use.
+// Subscriptions are never released, so number of distinct filters is capped by Config.MaxStreams
+func (g *Gateway) stream(filter string) (*stream, error) {
+	g.Lock()
+	defer g.Unlock()
+	if s, ok := g.streams[filter]; ok {
+		return s, nil
+	}
+	if len(g.streams) >= g.cfg.MaxStreams {
+		return nil, ErrTooManyStreams
+	}
+	sub, err := g.cfg.Node.GetSubscription(filter, zerosvc.SubscriptionOptions{
+		BufferSize: 256,
+		Overflow:   zerosvc.OverflowDropOldest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{clients: map[chan zerosvc.Event]struct{}{}}
+	go func() {
+		for ev := range sub.Events {
+			s.Lock()
+			for c := range s.clients {
+				select {
+				case c <- ev:
+				default:
+					g.l.Debugf("client of [%s] too slow, dropping event", filter)
+				}
+			}
+			s.Unlock()
+		}
+	}()
+	g.streams[filter] = s
+	return s, nil
+}
+
+func (s *stream) add() chan zerosvc.Event {
+	c := make(chan zerosvc.Event, clientBuffer)
+	s.Lock()
+	s.clients[c] = struct{}{}
+	s.Unlock()
+	return c
+}
+
+func (s *stream) remove(c chan zerosvc.Event) {
+	s.Lock()
+	delete(s.clients, c)
+	s.Unlock()
+}
+
+// validFilter rejects empty filters and misplaced wildcards, which broker would refuse
+func validFilter(filter string) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("filter required")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i == len(levels)-1:
+		case level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("invalid filter [%s]", filter)
+		}
+	}
+	return nil
+}
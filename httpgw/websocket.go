@@ -0,0 +1,296 @@
+package httpgw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// HeaderWebSocketClient is set on events published by websocket client to its Policy.Client.
+// Value sent by the client itself is always removed
+const HeaderWebSocketClient = "_ws_client"
+
+// Policy decides what websocket client can do. Filters are relative to event root, like in GetEventsCh
+type Policy struct {
+	// Client identifies the client in HeaderWebSocketClient of events it publishes, if not empty
+	Client string
+	// Subscribe are filters client can subscribe to, or to any narrower filter
+	Subscribe []string
+	// Publish are filters matching paths client can publish to, and ask for replies on (reply_to).
+	// Events are signed by gateway's node
+	Publish []string
+}
+
+// CanSubscribe returns true if filter is covered by one of allowed subscribe filters
+func (p *Policy) CanSubscribe(filter string) bool {
+	for _, f := range p.Subscribe {
+		if filterCovers(f, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanPublish returns true if path matches one of allowed publish filters
+func (p *Policy) CanPublish(path string) bool {
+	for _, f := range p.Publish {
+		if filterCovers(f, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCovers returns true if every topic matching filter also matches allowed
+func filterCovers(allowed string, filter string) bool {
+	a := strings.Split(allowed, "/")
+	f := strings.Split(filter, "/")
+	for i, level := range a {
+		if level == "#" {
+			return true
+		}
+		if i >= len(f) {
+			return false
+		}
+		switch {
+		case f[i] == "#":
+			return false
+		case level == "+":
+		case level != f[i]:
+			return false
+		}
+	}
+	return len(a) == len(f)
+}
+
+// wsRequest is message from websocket client
+//
+//	{"op":"subscribe","id":"1","filter":"cakes/#"}
+//	{"op":"unsubscribe","id":"2","filter":"cakes/#"}
+//	{"op":"publish","id":"3","path":"cakes/cheese","event":{...EventJSON},"retain":false}
+type wsRequest struct {
+	Op     string            `json:"op"`
+	ID     string            `json:"id,omitempty"`
+	Filter string            `json:"filter,omitempty"`
+	Path   string            `json:"path,omitempty"`
+	Event  zerosvc.EventJSON `json:"event"`
+	Retain bool              `json:"retain,omitempty"`
+}
+
+// wsResponse is message to websocket client: "ack" or "error" of request with the same id, or "event" of subscription
+type wsResponse struct {
+	Op     string             `json:"op"`
+	ID     string             `json:"id,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Filter string             `json:"filter,omitempty"`
+	Event  *zerosvc.EventJSON `json:"event,omitempty"`
+}
+
+// wsClient is state of single websocket connection
+type wsClient struct {
+	g      *Gateway
+	conn   *websocket.Conn
+	policy Policy
+	out    chan wsResponse
+	done   chan struct{}
+	// subs are active subscriptions, with channel registered in the stream
+	subs map[string]chan zerosvc.Event
+	sync.Mutex
+}
+
+func (g *Gateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	policy, err := g.cfg.WebSocketPolicy(r)
+	if err != nil {
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err)
+		} else {
+			writeError(w, http.StatusForbidden, err)
+		}
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: g.cfg.CheckOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader already responded
+		g.l.Debugf("websocket upgrade failed: %s", err)
+		return
+	}
+	c := &wsClient{
+		g:      g,
+		conn:   conn,
+		policy: policy,
+		out:    make(chan wsResponse, 256),
+		done:   make(chan struct{}),
+		subs:   map[string]chan zerosvc.Event{},
+	}
+	go c.writeLoop()
+	c.readLoop()
+	close(c.done)
+	c.Lock()
+	for filter, ch := range c.subs {
+		if s, err := g.stream(filter); err == nil {
+			s.remove(ch)
+		}
+	}
+	c.Unlock()
+}
+
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(c.g.cfg.KeepAlive)
+	defer ping.Stop()
+	defer c.conn.Close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*10)); err != nil {
+				return
+			}
+		case m := <-c.out:
+			if err := c.conn.WriteJSON(m); err != nil {
+				c.g.l.Debugf("websocket write failed: %s", err)
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) readLoop() {
+	c.conn.SetReadLimit(c.g.cfg.MaxBodySize)
+	deadline := func() {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.g.cfg.KeepAlive * 2))
+	}
+	deadline()
+	c.conn.SetPongHandler(func(string) error {
+		deadline()
+		return nil
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		deadline()
+		var req wsRequest
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			c.reply(req.ID, fmt.Errorf("error decoding request: %w", err))
+			continue
+		}
+		switch req.Op {
+		case "subscribe":
+			c.reply(req.ID, c.subscribe(req.Filter))
+		case "unsubscribe":
+			c.reply(req.ID, c.unsubscribe(req.Filter))
+		case "publish":
+			c.reply(req.ID, c.publish(&req))
+		default:
+			c.reply(req.ID, fmt.Errorf("unknown op [%s]", req.Op))
+		}
+	}
+}
+
+// reply sends ack or error of request
+func (c *wsClient) reply(id string, err error) {
+	m := wsResponse{Op: "ack", ID: id}
+	if err != nil {
+		m = wsResponse{Op: "error", ID: id, Error: err.Error()}
+	}
+	select {
+	case c.out <- m:
+	case <-c.done:
+	}
+}
+
+func (c *wsClient) subscribe(filter string) error {
+	if err := validFilter(filter); err != nil {
+		return err
+	}
+	if !c.policy.CanSubscribe(filter) {
+		return fmt.Errorf("subscribing to [%s] not allowed", filter)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.subs[filter]; ok {
+		return nil
+	}
+	s, err := c.g.stream(filter)
+	if err != nil {
+		return err
+	}
+	ch := s.add()
+	c.subs[filter] = ch
+	go func() {
+		for {
+			select {
+			case <-c.done:
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				e := zerosvc.NewEventJSON(&ev)
+				select {
+				case c.out <- wsResponse{Op: "event", Filter: filter, Event: &e}:
+				default:
+					c.g.l.Debugf("websocket client too slow, dropping event on [%s]", ev.Topic)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *wsClient) unsubscribe(filter string) error {
+	c.Lock()
+	ch, ok := c.subs[filter]
+	delete(c.subs, filter)
+	c.Unlock()
+	if !ok {
+		return fmt.Errorf("not subscribed to [%s]", filter)
+	}
+	s, err := c.g.stream(filter)
+	if err != nil {
+		return err
+	}
+	s.remove(ch)
+	close(ch)
+	return nil
+}
+
+func (c *wsClient) publish(req *wsRequest) error {
+	if len(req.Path) == 0 || strings.ContainsAny(req.Path, "+#") {
+		return fmt.Errorf("invalid path [%s]", req.Path)
+	}
+	if !c.policy.CanPublish(req.Path) {
+		return fmt.Errorf("publishing to [%s] not allowed", req.Path)
+	}
+	ev, err := req.Event.Event(c.g.cfg.Node)
+	if err != nil {
+		return err
+	}
+	// replies are published by other nodes but on client's behalf, so reply topic has to be allowed too
+	if len(ev.ReplyTo) > 0 {
+		replyPath, found := strings.CutPrefix(ev.ReplyTo, c.g.cfg.Node.EventRoot()+"/")
+		if !found || strings.ContainsAny(replyPath, "+#") || !c.policy.CanPublish(replyPath) {
+			return fmt.Errorf("reply_to [%s] not allowed", ev.ReplyTo)
+		}
+	}
+	// client can't impersonate other one, or claim to be one if policy does not identify it
+	delete(ev.Headers, HeaderWebSocketClient)
+	if len(c.policy.Client) > 0 {
+		ev.Headers[HeaderWebSocketClient] = c.policy.Client
+	}
+	ev.SetRetain(req.Retain)
+	return c.g.cfg.Node.SendEvent(req.Path, ev)
+}
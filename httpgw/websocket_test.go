@@ -0,0 +1,140 @@
+package httpgw
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterCovers(t *testing.T) {
+	for _, c := range []struct {
+		allowed string
+		filter  string
+		covers  bool
+	}{
+		{"#", "cakes/#", true},
+		{"cakes/#", "cakes/cheese", true},
+		{"cakes/#", "cakes/+/x", true},
+		{"cakes/#", "cakes", true},
+		{"cakes/#", "pies/x", false},
+		{"cakes/+", "cakes/cheese", true},
+		{"cakes/+", "cakes/+", true},
+		{"cakes/+", "cakes/#", false},
+		{"cakes/+", "cakes/cheese/x", false},
+		{"cakes/cheese", "cakes/+", false},
+		{"cakes/cheese", "cakes/cheese", true},
+	} {
+		assert.Equal(t, c.covers, filterCovers(c.allowed, c.filter), "%s covers %s", c.allowed, c.filter)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	srv, broker := testGateway(t, Config{WebSocketPolicy: func(r *http.Request) (Policy, error) {
+		if r.URL.Query().Get("token") != "dash" {
+			return Policy{}, ErrUnauthenticated
+		}
+		return Policy{Client: "dashboard", Subscribe: []string{"cakes/#"}, Publish: []string{"orders/+"}}, nil
+	}})
+	baker := testNode(t, broker, "baker")
+	orders, err := baker.GetEventsCh("orders/#")
+	require.NoError(t, err)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=dash", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	request := func(req map[string]any) wsResponse {
+		require.NoError(t, conn.WriteJSON(req))
+		var resp wsResponse
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	resp1 := request(map[string]any{"op": "subscribe", "id": "1", "filter": "cakes/#"})
+	assert.Equal(t, wsResponse{Op: "ack", ID: "1"}, resp1)
+	resp1 = request(map[string]any{"op": "subscribe", "id": "2", "filter": "#"})
+	assert.Equal(t, "error", resp1.Op)
+	assert.Contains(t, resp1.Error, "not allowed")
+
+	ev := baker.NewEvent()
+	ev.Body = []byte(`{"kind":"cheese"}`)
+	require.NoError(t, baker.SendEvent("cakes/cheese", ev))
+	var got wsResponse
+	require.NoError(t, conn.ReadJSON(&got))
+	assert.Equal(t, "event", got.Op)
+	assert.Equal(t, "cakes/#", got.Filter)
+	require.NotNil(t, got.Event)
+	assert.Equal(t, "baker", got.Event.Node)
+	assert.JSONEq(t, `{"kind":"cheese"}`, string(got.Event.Body))
+
+	resp1 = request(map[string]any{"op": "publish", "id": "3", "path": "orders/42", "event": map[string]any{"body": "one cheesecake"}})
+	assert.Equal(t, wsResponse{Op: "ack", ID: "3"}, resp1)
+	published := goneric.ChanToSliceNTimeout(orders, 1, time.Second)
+	require.Len(t, published, 1)
+	assert.Equal(t, "gw", published[0].NodeName, "event should be sent by gateway node")
+	assert.Equal(t, "dashboard", published[0].Headers[HeaderWebSocketClient])
+	assert.Equal(t, []byte("one cheesecake"), published[0].Body)
+
+	resp1 = request(map[string]any{"op": "publish", "id": "4", "path": "cakes/free", "event": map[string]any{}})
+	assert.Equal(t, "error", resp1.Op)
+
+	resp1 = request(map[string]any{"op": "unsubscribe", "id": "5", "filter": "cakes/#"})
+	assert.Equal(t, wsResponse{Op: "ack", ID: "5"}, resp1)
+	require.NoError(t, baker.SendEvent("cakes/cheese", baker.NewEvent()))
+	resp1 = request(map[string]any{"op": "nope", "id": "6"})
+	assert.Equal(t, "6", resp1.ID, "no events should arrive after unsubscribe")
+}
+
+func TestWebSocketDisabled(t *testing.T) {
+	srv, _ := testGateway(t, Config{})
+	resp, err := http.Get(srv.URL + "/ws")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWebSocketPublishSanitized(t *testing.T) {
+	srv, broker := testGateway(t, Config{WebSocketPolicy: func(r *http.Request) (Policy, error) {
+		return Policy{Publish: []string{"orders/+"}}, nil
+	}})
+	baker := testNode(t, broker, "baker")
+	orders, err := baker.GetEventsCh("orders/#")
+	require.NoError(t, err)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	publish := func(id string, event map[string]any) wsResponse {
+		require.NoError(t, conn.WriteJSON(map[string]any{"op": "publish", "id": id, "path": "orders/42", "event": event}))
+		var resp wsResponse
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	resp := publish("1", map[string]any{"headers": map[string]any{HeaderWebSocketClient: "admin"}})
+	assert.Equal(t, wsResponse{Op: "ack", ID: "1"}, resp)
+	published := goneric.ChanToSliceNTimeout(orders, 1, time.Second)
+	require.Len(t, published, 1)
+	assert.NotContains(t, published[0].Headers, HeaderWebSocketClient, "client-supplied identity should be removed")
+
+	for _, replyTo := range []string{"test/admin/cmd", "test/orders/#", "other/orders/43"} {
+		resp = publish("2", map[string]any{"reply_to": replyTo})
+		assert.Equal(t, "error", resp.Op, replyTo)
+	}
+	resp = publish("3", map[string]any{"reply_to": "test/orders/43"})
+	assert.Equal(t, wsResponse{Op: "ack", ID: "3"}, resp)
+	published = goneric.ChanToSliceNTimeout(orders, 1, time.Second)
+	require.Len(t, published, 1)
+	assert.Equal(t, "test/orders/43", published[0].ReplyTo)
+}
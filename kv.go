@@ -0,0 +1,276 @@
+package zerosvc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type KVConfig struct {
+	Node *Node
+	// Bucket name; entries are stored under kv/<bucket>/<key>
+	Bucket string
+	// RequireSigned ignores entries without signature verified by node's PubkeyRetriever
+	RequireSigned bool
+}
+
+// KV is a key-value store kept in retained events under <eventRoot>/kv/<bucket>/<key>.
+//
+// Every node using the bucket keeps a local cache fed by subscription, so reads are local and eventually consistent.
+// Concurrent writes are resolved by last-writer-wins on (timestamp, node UUID), so every node ends with the same value.
+// Deletes are stored as retained tombstones, so a stale write can't resurrect deleted key
+type KV struct {
+	cfg    KVConfig
+	n      *Node
+	prefix string
+	sync.Mutex
+	entries  map[string]KVEntry
+	watchers map[*KVWatcher]bool
+}
+
+type KVEntry struct {
+	Key   string
+	Value []byte
+	// Revision is incremented by every write of the key
+	Revision uint64
+	// Deleted is set on entries sent to watchers when key was deleted
+	Deleted bool
+	// TS of the write
+	TS time.Time
+	// NodeName and NodeUUID of the writer
+	NodeName string
+	NodeUUID string
+}
+
+// newer returns true if e should replace old
+func (e KVEntry) newer(old KVEntry) bool {
+	if !e.TS.Equal(old.TS) {
+		return e.TS.After(old.TS)
+	}
+	return e.NodeUUID > old.NodeUUID
+}
+
+// kvRecord is the event body. TS is unix nanoseconds, event TS is only second-precision on the wire
+type kvRecord struct {
+	Value    []byte `cbor:"v,omitempty" json:"v,omitempty"`
+	Revision uint64 `cbor:"rev" json:"rev"`
+	TS       int64  `cbor:"ts" json:"ts"`
+	Deleted  bool   `cbor:"del,omitempty" json:"del,omitempty"`
+}
+
+// KVWatcher receives changes of keys under prefix
+type KVWatcher struct {
+	Updates chan KVEntry
+	prefix  string
+	kv      *KV
+	dropped atomic.Uint64
+}
+
+// Dropped returns number of changes dropped because Updates was full
+func (w *KVWatcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func NewKV(cfg KVConfig) (*KV, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if len(cfg.Bucket) == 0 || strings.ContainsAny(cfg.Bucket, "/+#") {
+		return nil, fmt.Errorf("invalid bucket name [%s]", cfg.Bucket)
+	}
+	kv := &KV{
+		cfg:      cfg,
+		n:        cfg.Node,
+		prefix:   "kv/" + cfg.Bucket + "/",
+		entries:  map[string]KVEntry{},
+		watchers: map[*KVWatcher]bool{},
+	}
+	messages := make(chan *Message, DefaultEventBufferSize)
+	go kv.receive(messages)
+	// direct transport subscription, entries are decoded by receive
+	err := kv.n.tr.Subscribe(kv.n.eventRoot+"/"+kv.prefix+"#", messages)
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+func validateKVKey(key string) error {
+	if len(key) == 0 || strings.ContainsAny(key, "+#") {
+		return fmt.Errorf("invalid key [%s]", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if len(part) == 0 {
+			return fmt.Errorf("invalid key [%s]: empty path segment", key)
+		}
+	}
+	return nil
+}
+
+// Put sets the key and returns new revision
+func (kv *KV) Put(key string, value []byte) (revision uint64, err error) {
+	return kv.write(key, value, false)
+}
+
+// Delete removes the key
+func (kv *KV) Delete(key string) error {
+	_, err := kv.write(key, nil, true)
+	return err
+}
+
+func (kv *KV) write(key string, value []byte, deleted bool) (uint64, error) {
+	if err := validateKVKey(key); err != nil {
+		return 0, err
+	}
+	kv.Lock()
+	old := kv.entries[key]
+	kv.Unlock()
+	now := time.Now()
+	// keep the write ordered after the one we know about even if clocks disagree
+	if !now.After(old.TS) {
+		now = old.TS.Add(time.Nanosecond)
+	}
+	rec := kvRecord{
+		Value:    value,
+		Revision: old.Revision + 1,
+		TS:       now.UnixNano(),
+		Deleted:  deleted,
+	}
+	ev := kv.n.NewEvent()
+	if err := ev.Marshal(rec); err != nil {
+		return 0, err
+	}
+	ev.SetRetain(true)
+	if err := kv.n.SendEvent(kv.prefix+key, ev); err != nil {
+		return 0, err
+	}
+	kv.apply(KVEntry{
+		Key:      key,
+		Value:    value,
+		Revision: rec.Revision,
+		Deleted:  deleted,
+		TS:       time.Unix(0, rec.TS),
+		NodeName: kv.n.Name,
+		NodeUUID: kv.n.UUID,
+	})
+	return rec.Revision, nil
+}
+
+// Get returns value of the key from local cache
+func (kv *KV) Get(key string) (entry KVEntry, found bool) {
+	kv.Lock()
+	defer kv.Unlock()
+	entry, found = kv.entries[key]
+	if entry.Deleted {
+		return KVEntry{}, false
+	}
+	return entry, found
+}
+
+// Keys returns sorted list of existing keys starting with prefix
+func (kv *KV) Keys(prefix string) []string {
+	kv.Lock()
+	defer kv.Unlock()
+	keys := []string{}
+	for k, e := range kv.entries {
+		if !e.Deleted && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Watch returns watcher getting every change of keys starting with prefix, beginning with current values.
+// Updates is sized to fit current values plus DefaultEventBufferSize changes.
+// Changes that do not fit are dropped and counted in Dropped, so slow watcher can't block the KV;
+// cache itself is still updated, so watcher can catch up with Get
+func (kv *KV) Watch(prefix string) *KVWatcher {
+	kv.Lock()
+	defer kv.Unlock()
+	current := []KVEntry{}
+	for k, e := range kv.entries {
+		if !e.Deleted && strings.HasPrefix(k, prefix) {
+			current = append(current, e)
+		}
+	}
+	sort.Slice(current, func(i, j int) bool { return current[i].Key < current[j].Key })
+	w := &KVWatcher{
+		Updates: make(chan KVEntry, len(current)+DefaultEventBufferSize),
+		prefix:  prefix,
+		kv:      kv,
+	}
+	// queued under lock so changes received meanwhile can't overtake current values
+	for _, e := range current {
+		w.Updates <- e
+	}
+	kv.watchers[w] = true
+	return w
+}
+
+// Stop stops the updates
+func (w *KVWatcher) Stop() {
+	w.kv.Lock()
+	defer w.kv.Unlock()
+	delete(w.kv.watchers, w)
+}
+
+func (kv *KV) receive(messages chan *Message) {
+	topicPrefix := kv.n.eventRoot + "/" + kv.prefix
+	for m := range messages {
+		// cleared topic, not something KV does itself
+		if len(m.Payload) == 0 {
+			continue
+		}
+		key := strings.TrimPrefix(m.Topic, topicPrefix)
+		ev, err := (&Event{}).Deserialize(m.Payload, kv.n)
+		if err != nil {
+			kv.n.l.Warnf("kv %s: bad entry on [%s]: %s", kv.cfg.Bucket, m.Topic, err)
+			continue
+		}
+		if kv.cfg.RequireSigned && !ev.Verified {
+			kv.n.l.Warnf("kv %s: ignoring entry without verified signature on [%s] from %s[%s]", kv.cfg.Bucket, m.Topic, ev.NodeName, ev.NodeUUID)
+			continue
+		}
+		var rec kvRecord
+		if err := ev.Unmarshal(&rec); err != nil {
+			kv.n.l.Warnf("kv %s: bad entry on [%s]: %s", kv.cfg.Bucket, m.Topic, err)
+			continue
+		}
+		kv.apply(KVEntry{
+			Key:      key,
+			Value:    rec.Value,
+			Revision: rec.Revision,
+			Deleted:  rec.Deleted,
+			TS:       time.Unix(0, rec.TS),
+			NodeName: ev.NodeName,
+			NodeUUID: ev.NodeUUID,
+		})
+	}
+}
+
+// apply updates cache if entry wins over cached one and notifies watchers
+func (kv *KV) apply(e KVEntry) {
+	kv.Lock()
+	if old, ok := kv.entries[e.Key]; ok && !e.newer(old) {
+		kv.Unlock()
+		return
+	}
+	kv.entries[e.Key] = e
+	// under lock, so concurrent changes reach watchers in cache order
+	defer kv.Unlock()
+	for w := range kv.watchers {
+		if !strings.HasPrefix(e.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.Updates <- e:
+		default:
+			w.dropped.Add(1)
+			kv.n.l.Warnf("kv %s: watcher of [%s] not keeping up, dropped change of [%s]", kv.cfg.Bucket, w.prefix, e.Key)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package zerosvc
+
+import (
+	"fmt"
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestKV(t *testing.T) {
+	broker := NewMemoryBroker()
+	newKV := func(name string) *KV {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		kv, err := NewKV(KVConfig{Node: n, Bucket: "config"})
+		require.NoError(t, err)
+		return kv
+	}
+	eventually := func(f func() bool) {
+		t.Helper()
+		require.Eventually(t, f, time.Second, time.Millisecond*5)
+	}
+	a := newKV("a")
+	b := newKV("b")
+	w := b.Watch("oven/")
+
+	rev, err := a.Put("oven/temp", []byte("180"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), rev)
+	e, found := a.Get("oven/temp")
+	require.True(t, found, "writer should read its own write")
+	assert.Equal(t, []byte("180"), e.Value)
+	eventually(func() bool {
+		e, found := b.Get("oven/temp")
+		return found && string(e.Value) == "180"
+	})
+	updates := goneric.ChanToSliceNTimeout(w.Updates, 1, time.Second)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "oven/temp", updates[0].Key)
+	assert.Equal(t, "a", updates[0].NodeName)
+
+	rev, err = b.Put("oven/temp", []byte("200"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), rev)
+	_, err = b.Put("fridge/temp", []byte("4"))
+	require.NoError(t, err)
+	eventually(func() bool {
+		e, _ := a.Get("oven/temp")
+		return e.Revision == 2 && len(a.Keys("")) == 2
+	})
+	assert.Equal(t, []string{"fridge/temp", "oven/temp"}, a.Keys(""))
+	assert.Equal(t, []string{"oven/temp"}, a.Keys("oven/"))
+	updates = goneric.ChanToSliceNTimeout(w.Updates, 2, time.Millisecond*100)
+	require.Len(t, updates, 1, "watcher should only get keys under prefix")
+	assert.Equal(t, []byte("200"), updates[0].Value)
+
+	// late joiner gets state from retained entries
+	c := newKV("c")
+	eventually(func() bool { return len(c.Keys("")) == 2 })
+	cw := c.Watch("")
+	assert.Len(t, goneric.ChanToSliceNTimeout(cw.Updates, 2, time.Second), 2)
+
+	require.NoError(t, a.Delete("oven/temp"))
+	_, found = a.Get("oven/temp")
+	assert.False(t, found)
+	eventually(func() bool {
+		_, found := c.Get("oven/temp")
+		return !found
+	})
+	updates = goneric.ChanToSliceNTimeout(w.Updates, 1, time.Second)
+	require.Len(t, updates, 1)
+	assert.True(t, updates[0].Deleted)
+	w.Stop()
+	_, err = a.Put("oven/temp", []byte("220"))
+	require.NoError(t, err)
+	assert.Empty(t, goneric.ChanToSliceNTimeout(w.Updates, 1, time.Millisecond*50))
+
+	_, err = a.Put("bad/+", nil)
+	assert.Error(t, err)
+	_, err = a.Put("bad//key", nil)
+	assert.Error(t, err)
+	_, err = NewKV(KVConfig{Node: a.n, Bucket: "a/b"})
+	assert.Error(t, err)
+}
+
+func TestKVLastWriterWins(t *testing.T) {
+	kv, err := NewKV(KVConfig{Node: getTestDummyNode(t), Bucket: "lww"})
+	require.NoError(t, err)
+	ts := time.Now()
+	kv.apply(KVEntry{Key: "k", Value: []byte("b"), TS: ts, NodeUUID: "bbb"})
+	kv.apply(KVEntry{Key: "k", Value: []byte("a"), TS: ts, NodeUUID: "aaa"})
+	e, _ := kv.Get("k")
+	assert.Equal(t, []byte("b"), e.Value, "same timestamp, higher UUID wins")
+	kv.apply(KVEntry{Key: "k", Value: []byte("old"), TS: ts.Add(-time.Second), NodeUUID: "zzz"})
+	e, _ = kv.Get("k")
+	assert.Equal(t, []byte("b"), e.Value)
+	kv.apply(KVEntry{Key: "k", Deleted: true, TS: ts.Add(time.Second), NodeUUID: "aaa"})
+	kv.apply(KVEntry{Key: "k", Value: []byte("stale"), TS: ts.Add(time.Millisecond), NodeUUID: "zzz"})
+	_, found := kv.Get("k")
+	assert.False(t, found, "stale write should not resurrect deleted key")
+}
+
+func TestKVWatchLargeSnapshot(t *testing.T) {
+	kv, err := NewKV(KVConfig{Node: getTestDummyNode(t), Bucket: "large"})
+	require.NoError(t, err)
+	keys := DefaultEventBufferSize * 2
+	for i := 0; i < keys; i++ {
+		kv.apply(KVEntry{Key: fmt.Sprintf("k%03d", i), Value: []byte("v"), TS: time.Now()})
+	}
+	watchers := make(chan *KVWatcher)
+	go func() { watchers <- kv.Watch("") }()
+	var w *KVWatcher
+	select {
+	case w = <-watchers:
+	case <-time.After(time.Second):
+		t.Fatal("Watch blocked on snapshot larger than buffer")
+	}
+	kv.apply(KVEntry{Key: "k000", Value: []byte("new"), TS: time.Now().Add(time.Second)})
+	updates := goneric.ChanToSliceNTimeout(w.Updates, keys+1, time.Second)
+	require.Len(t, updates, keys+1)
+	assert.Equal(t, "k000", updates[0].Key)
+	assert.Equal(t, []byte("v"), updates[0].Value, "current values should come before later changes")
+	assert.Equal(t, []byte("new"), updates[keys].Value)
+}
+
+func TestKVSlowWatcher(t *testing.T) {
+	kv, err := NewKV(KVConfig{Node: getTestDummyNode(t), Bucket: "slow"})
+	require.NoError(t, err)
+	w := kv.Watch("")
+	changes := DefaultEventBufferSize * 2
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < changes; i++ {
+			kv.apply(KVEntry{Key: "k", Value: []byte(fmt.Sprint(i)), TS: time.Now().Add(time.Duration(i))})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("slow watcher blocked the KV")
+	}
+	assert.Equal(t, uint64(changes-DefaultEventBufferSize), w.Dropped())
+	updates := goneric.ChanToSliceNTimeout(w.Updates, changes, time.Millisecond*100)
+	require.Len(t, updates, DefaultEventBufferSize)
+	assert.Equal(t, []byte("0"), updates[0].Value, "oldest changes should be kept")
+	e, _ := kv.Get("k")
+	assert.Equal(t, []byte(fmt.Sprint(changes-1)), e.Value, "cache should have latest value")
+}
+
+func TestKVRequireSigned(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string, signer Signer) *Node {
+		tr, _ := NewTransportMemory(ConfigMemory{Broker: broker})
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test", Signer: signer})
+		require.NoError(t, err)
+		return n
+	}
+	signer := goneric.Must(NewSignerEd25519())
+	unsigned := newNode("unsigned", nil)
+	// signs with key strict node does not know
+	forger := newNode("forger", goneric.Must(NewSignerEd25519()))
+	trusted := newNode("trusted", signer)
+	strict := newNode("strict", nil)
+	strict.PubkeyRetriever = func(nodeName string, nodeUUID string) (Verifier, bool) {
+		return signer, nodeName == "trusted"
+	}
+	strictKV, err := NewKV(KVConfig{Node: strict, Bucket: "secure", RequireSigned: true})
+	require.NoError(t, err)
+	for _, n := range []*Node{unsigned, forger} {
+		kv, err := NewKV(KVConfig{Node: n, Bucket: "secure"})
+		require.NoError(t, err)
+		_, err = kv.Put(n.Name, []byte("evil"))
+		require.NoError(t, err)
+	}
+	trustedKV, err := NewKV(KVConfig{Node: trusted, Bucket: "secure"})
+	require.NoError(t, err)
+	_, err = trustedKV.Put("trusted", []byte("good"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, found := strictKV.Get("trusted")
+		return found
+	}, time.Second, time.Millisecond*5)
+	_, found := strictKV.Get("unsigned")
+	assert.False(t, found)
+	_, found = strictKV.Get("forger")
+	assert.False(t, found, "signature with unknown key should not count as signed")
+}
@@ -0,0 +1,319 @@
+package zerosvc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// NodeConfig is serializable node and transport configuration, see LoadConfig
+type NodeConfig struct {
+	// URLs of brokers; TLS options and credentials are taken from the first one. See "MQTT URL options"
+	URLs []string `yaml:"urls" json:"urls"`
+	// Protocol is MQTT version, 3 or 5; 5 if 0
+	Protocol int `yaml:"protocol" json:"protocol"`
+	// ClientID of MQTT connection, random "zerosvc-<hex>" if empty, so instances sharing config do not kick each other off
+	ClientID string `yaml:"client_id" json:"client_id"`
+	// NodeName, GetFQDN()@<binary name> if empty
+	NodeName string `yaml:"node_name" json:"node_name"`
+	// NodeUUID, derived from NodeName if empty
+	NodeUUID  string `yaml:"node_uuid" json:"node_uuid"`
+	EventRoot string `yaml:"event_root" json:"event_root"`
+	// HeartbeatInterval in time.ParseDuration format, NewNode() default if empty
+	HeartbeatInterval string `yaml:"heartbeat_interval" json:"heartbeat_interval"`
+	// SignerKey is path to Ed25519 private key (see ParseEd25519Key); events are not signed if empty
+	SignerKey string `yaml:"signer_key" json:"signer_key"`
+	// Codec of events, "cbor" (default) or "json"
+	Codec string `yaml:"codec" json:"codec"`
+	// LogLevel is one of debug, info, warn, error; node does not log if empty
+	LogLevel string `yaml:"log_level" json:"log_level"`
+	// sources maps field to environment variable it was set from, for error messages
+	sources map[string]string
+}
+
+// ErrConfigField is returned when config field is missing or invalid
+type ErrConfigField struct {
+	// Field is the name used in config file, or environment variable it was set from
+	Field string
+	Err   error
+}
+
+func (e ErrConfigField) Error() string {
+	return fmt.Sprintf("config field [%s]: %s", e.Field, e.Err)
+}
+
+func (e ErrConfigField) Unwrap() error {
+	return e.Err
+}
+
+// LoadConfig reads config from YAML or JSON file, if path is not empty,
+// then overrides it with environment variables (see ApplyEnv) and validates it
+func LoadConfig(path string) (*NodeConfig, error) {
+	c := &NodeConfig{}
+	if len(path) > 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config: %w", err)
+		}
+		// JSON is valid YAML
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+	}
+	if err := c.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		if len(path) > 0 {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// configEnv maps field name to environment variable. URL and root match the ones used by the CLI
+var configEnv = []struct {
+	field string
+	env   string
+}{
+	{"urls", "ZEROSVC_URL"},
+	{"protocol", "ZEROSVC_PROTOCOL"},
+	{"client_id", "ZEROSVC_CLIENT_ID"},
+	{"node_name", "ZEROSVC_NODE_NAME"},
+	{"node_uuid", "ZEROSVC_NODE_UUID"},
+	{"event_root", "ZEROSVC_ROOT"},
+	{"heartbeat_interval", "ZEROSVC_HEARTBEAT_INTERVAL"},
+	{"signer_key", "ZEROSVC_SIGNER_KEY"},
+	{"codec", "ZEROSVC_CODEC"},
+	{"log_level", "ZEROSVC_LOG_LEVEL"},
+}
+
+// ApplyEnv overrides fields with ZEROSVC_URL (comma-separated), ZEROSVC_PROTOCOL, ZEROSVC_CLIENT_ID,
+// ZEROSVC_NODE_NAME, ZEROSVC_NODE_UUID, ZEROSVC_ROOT, ZEROSVC_HEARTBEAT_INTERVAL, ZEROSVC_SIGNER_KEY,
+// ZEROSVC_CODEC and ZEROSVC_LOG_LEVEL environment variables, if set
+func (c *NodeConfig) ApplyEnv() error {
+	for _, e := range configEnv {
+		v, ok := os.LookupEnv(e.env)
+		if !ok {
+			continue
+		}
+		switch e.field {
+		case "urls":
+			c.URLs = nil
+			for _, u := range strings.Split(v, ",") {
+				if u = strings.TrimSpace(u); len(u) > 0 {
+					c.URLs = append(c.URLs, u)
+				}
+			}
+		case "protocol":
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return ErrConfigField{Field: e.env, Err: fmt.Errorf("not a number: %q", v)}
+			}
+			c.Protocol = p
+		case "client_id":
+			c.ClientID = v
+		case "node_name":
+			c.NodeName = v
+		case "node_uuid":
+			c.NodeUUID = v
+		case "event_root":
+			c.EventRoot = v
+		case "heartbeat_interval":
+			c.HeartbeatInterval = v
+		case "signer_key":
+			c.SignerKey = v
+		case "codec":
+			c.Codec = v
+		case "log_level":
+			c.LogLevel = v
+		}
+		if c.sources == nil {
+			c.sources = map[string]string{}
+		}
+		c.sources[e.field] = e.env
+	}
+	return nil
+}
+
+func (c *NodeConfig) fieldErr(field string, format string, args ...any) error {
+	if env, ok := c.sources[field]; ok {
+		field = env
+	}
+	return ErrConfigField{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate checks fields without touching the network or key files
+func (c *NodeConfig) Validate() error {
+	if len(c.URLs) == 0 {
+		return c.fieldErr("urls", "at least one broker URL required")
+	}
+	for _, u := range c.URLs {
+		if _, err := c.parseURL(u); err != nil {
+			return err
+		}
+	}
+	if c.Protocol != 0 && c.Protocol != 3 && c.Protocol != 5 {
+		return c.fieldErr("protocol", "MQTT version has to be 3 or 5, got %d", c.Protocol)
+	}
+	if _, err := c.heartbeatInterval(); err != nil {
+		return err
+	}
+	if _, _, err := c.codec(); err != nil {
+		return err
+	}
+	if _, err := c.logLevel(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *NodeConfig) parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, c.fieldErr("urls", "%s", err)
+	}
+	switch u.Scheme {
+	case "tcp", "ssl", "ws", "wss", "mqtt", "mqtts", "tls":
+	default:
+		return nil, c.fieldErr("urls", "unsupported scheme [%s] in [%s]", u.Scheme, u.Redacted())
+	}
+	if len(u.Host) == 0 {
+		return nil, c.fieldErr("urls", "no host in [%s]", u.Redacted())
+	}
+	return u, nil
+}
+
+func (c *NodeConfig) heartbeatInterval() (time.Duration, error) {
+	if len(c.HeartbeatInterval) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.HeartbeatInterval)
+	if err != nil {
+		return 0, c.fieldErr("heartbeat_interval", "%s", err)
+	}
+	if d <= 0 {
+		return 0, c.fieldErr("heartbeat_interval", "has to be positive, got %s", d)
+	}
+	return d, nil
+}
+
+func (c *NodeConfig) codec() (Encoder, Decoder, error) {
+	switch c.Codec {
+	case "", "cbor":
+		// NewNode() defaults
+		return nil, nil, nil
+	case "json":
+		return jsonCodec{}, jsonCodec{}, nil
+	default:
+		return nil, nil, c.fieldErr("codec", "unknown codec [%s], use cbor or json", c.Codec)
+	}
+}
+
+func (c *NodeConfig) logLevel() (*zapcore.Level, error) {
+	if len(c.LogLevel) == 0 {
+		return nil, nil
+	}
+	lvl, err := zapcore.ParseLevel(c.LogLevel)
+	if err != nil || lvl > zapcore.ErrorLevel {
+		return nil, c.fieldErr("log_level", "unknown level [%s], use debug, info, warn or error", c.LogLevel)
+	}
+	return &lvl, nil
+}
+
+// Config builds node config with new, not yet connected transport, so caller can adjust it before NewNode()
+func (c *NodeConfig) Config() (Config, error) {
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	cfg := Config{
+		NodeName:  c.NodeName,
+		NodeUUID:  c.NodeUUID,
+		EventRoot: c.EventRoot,
+	}
+	if len(cfg.NodeName) == 0 {
+		cfg.NodeName = GetFQDN() + "@" + filepath.Base(os.Args[0])
+	}
+	cfg.HeartbeatInterval, _ = c.heartbeatInterval()
+	cfg.Encoder, cfg.Decoder, _ = c.codec()
+	if lvl, _ := c.logLevel(); lvl != nil {
+		zc := zap.NewProductionConfig()
+		zc.Level = zap.NewAtomicLevelAt(*lvl)
+		logger, err := zc.Build()
+		if err != nil {
+			return Config{}, c.fieldErr("log_level", "error setting up logger: %s", err)
+		}
+		cfg.Logger = logger.Sugar()
+	}
+	if len(c.SignerKey) > 0 {
+		data, err := os.ReadFile(c.SignerKey)
+		if err != nil {
+			return Config{}, c.fieldErr("signer_key", "%s", err)
+		}
+		priv, _, err := ParseEd25519Key(data, true)
+		if err != nil {
+			return Config{}, c.fieldErr("signer_key", "%s: %s", c.SignerKey, err)
+		}
+		if priv == nil {
+			return Config{}, c.fieldErr("signer_key", "%s: private key required, got public one", c.SignerKey)
+		}
+		cfg.Signer, err = NewSignerEd25519(priv)
+		if err != nil {
+			return Config{}, c.fieldErr("signer_key", "%s: %s", c.SignerKey, err)
+		}
+	}
+	urls := make([]*url.URL, 0, len(c.URLs))
+	for _, s := range c.URLs {
+		u, _ := c.parseURL(s)
+		urls = append(urls, u)
+	}
+	clientID := c.ClientID
+	if len(clientID) == 0 {
+		clientID = "zerosvc-" + hex.EncodeToString(rngBlob(6))
+	}
+	var err error
+	switch c.Protocol {
+	case 3:
+		cfg.Transport, err = NewTransportMQTTv3(ConfigMQTTv3{ID: clientID, MQTTURL: urls})
+	default:
+		cfg.Transport, err = NewTransportMQTTv5(ConfigMQTTv5{ID: clientID, MQTTURL: urls, Logger: cfg.Logger})
+	}
+	if err != nil {
+		return Config{}, c.fieldErr("urls", "error setting up transport: %s", err)
+	}
+	return cfg, nil
+}
+
+// NewNode builds config and connects new node with it
+func (c *NodeConfig) NewNode() (*Node, error) {
+	cfg, err := c.Config()
+	if err != nil {
+		return nil, err
+	}
+	return NewNode(cfg)
+}
+
+// jsonCodec is JSON Encoder and Decoder
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,135 @@
+package zerosvc
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	keyPath := writeTestConfig(t, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+
+	path := writeTestConfig(t, "node.yaml", `
+urls:
+  - tcp://127.0.0.1:1883
+  - tcp://127.0.0.2:1883
+protocol: 3
+node_name: oven@bakery
+event_root: site-a
+heartbeat_interval: 30s
+signer_key: `+keyPath+`
+codec: json
+log_level: warn
+`)
+	t.Setenv("ZEROSVC_ROOT", "site-b")
+	c, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, c.URLs, 2)
+	assert.Equal(t, "oven@bakery", c.NodeName)
+	assert.Equal(t, "site-b", c.EventRoot, "env should override file")
+
+	cfg, err := c.Config()
+	require.NoError(t, err)
+	assert.IsType(t, &TransportMQTTv3{}, cfg.Transport)
+	assert.Equal(t, time.Second*30, cfg.HeartbeatInterval)
+	assert.Equal(t, "site-b", cfg.EventRoot)
+	require.NotNil(t, cfg.Signer)
+	assert.Equal(t, []byte(priv), cfg.Signer.PrivateKey())
+	assert.NotNil(t, cfg.Logger)
+	assert.IsType(t, jsonCodec{}, cfg.Encoder)
+}
+
+func TestLoadConfigJSONEnv(t *testing.T) {
+	path := writeTestConfig(t, "node.json", `{"urls": ["tcp://127.0.0.1:1883"], "event_root": "test"}`)
+	t.Setenv("ZEROSVC_URL", "ssl://broker1:8883, ssl://broker2:8883")
+	c, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssl://broker1:8883", "ssl://broker2:8883"}, c.URLs)
+	cfg, err := c.Config()
+	require.NoError(t, err)
+	assert.IsType(t, &TransportMQTTv5{}, cfg.Transport)
+	assert.Equal(t, GetFQDN()+"@"+filepath.Base(os.Args[0]), cfg.NodeName)
+	clientID := cfg.Transport.(*TransportMQTTv5).mqttCfg.ClientID
+	assert.Regexp(t, "^zerosvc-[0-9a-f]{12}$", clientID)
+	other, err := c.Config()
+	require.NoError(t, err)
+	assert.NotEqual(t, clientID, other.Transport.(*TransportMQTTv5).mqttCfg.ClientID, "instances should not share client ID")
+	assert.Nil(t, cfg.Signer)
+	assert.Nil(t, cfg.Logger)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	base := "urls: [tcp://127.0.0.1:1883]\n"
+	for _, tc := range []struct {
+		name   string
+		config string
+		env    map[string]string
+		field  string
+	}{
+		{name: "no urls", config: "event_root: test\n", field: "urls"},
+		{name: "bad scheme", config: "urls: [http://127.0.0.1]\n", field: "urls"},
+		{name: "protocol", config: base + "protocol: 4\n", field: "protocol"},
+		{name: "heartbeat", config: base + "heartbeat_interval: often\n", field: "heartbeat_interval"},
+		{name: "codec", config: base + "codec: xml\n", field: "codec"},
+		{name: "log level", config: base + "log_level: loud\n", field: "log_level"},
+		{name: "env protocol", config: base, env: map[string]string{"ZEROSVC_PROTOCOL": "five"}, field: "ZEROSVC_PROTOCOL"},
+		{name: "env codec", config: base, env: map[string]string{"ZEROSVC_CODEC": "xml"}, field: "ZEROSVC_CODEC"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadConfig(writeTestConfig(t, "node.yaml", tc.config))
+			var fieldErr ErrConfigField
+			require.True(t, errors.As(err, &fieldErr), "%v", err)
+			assert.Equal(t, tc.field, fieldErr.Field)
+			assert.Contains(t, err.Error(), "["+tc.field+"]")
+		})
+	}
+	_, err := LoadConfig(writeTestConfig(t, "node.yaml", base+"brokers: [x]\n"))
+	assert.ErrorContains(t, err, "brokers", "unknown fields should be rejected")
+
+	c := &NodeConfig{URLs: []string{"tcp://127.0.0.1:1883"}, SignerKey: filepath.Join(t.TempDir(), "missing")}
+	_, err = c.Config()
+	assert.ErrorContains(t, err, "[signer_key]")
+}
+
+func TestNodeConfigJSONCodec(t *testing.T) {
+	c := &NodeConfig{URLs: []string{"tcp://127.0.0.1:1883"}, Codec: "json", EventRoot: "test"}
+	cfg, err := c.Config()
+	require.NoError(t, err)
+	broker := NewMemoryBroker()
+	cfg.Transport, err = NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	node, err := NewNode(cfg)
+	require.NoError(t, err)
+	events, err := node.GetEventsCh("test/#")
+	require.NoError(t, err)
+	ev := node.NewEvent()
+	ev.Headers["cake"] = "chocolate"
+	ev.Body = []byte("body")
+	require.NoError(t, node.SendEvent("test/json", ev))
+	got := goneric.ChanToSliceNTimeout(events, 1, time.Second)
+	require.Len(t, got, 1)
+	assert.Equal(t, "chocolate", got[0].Headers["cake"])
+	assert.Equal(t, []byte("body"), got[0].Body)
+}
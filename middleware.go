@@ -0,0 +1,123 @@
+package zerosvc
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"runtime/debug"
+)
+
+// PublishHandler sends event to path (relative to event root)
+type PublishHandler func(path string, ev *Event) error
+
+// PublishMiddleware wraps SendEvent. It can modify the event or stop it by returning error without calling next
+type PublishMiddleware func(next PublishHandler) PublishHandler
+
+// ReceiveHandler handles received event. topic is full topic the event arrived on
+type ReceiveHandler func(topic string, ev *Event) error
+
+// ReceiveMiddleware wraps delivery of decoded events to GetEventsCh() consumers.
+// Returning error drops the event and passes the error to node's ErrorHook
+type ReceiveMiddleware func(next ReceiveHandler) ReceiveHandler
+
+// UsePublish adds middlewares to SendEvent chain. First added middleware is the outermost
+func (n *Node) UsePublish(mw ...PublishMiddleware) {
+	n.Lock()
+	defer n.Unlock()
+	n.publishMw = append(n.publishMw, mw...)
+}
+
+// UseReceive adds middlewares to receive chain. First added middleware is the outermost
+func (n *Node) UseReceive(mw ...ReceiveMiddleware) {
+	n.Lock()
+	defer n.Unlock()
+	n.receiveMw = append(n.receiveMw, mw...)
+}
+
+func (n *Node) publishChain(h PublishHandler) PublishHandler {
+	n.RLock()
+	defer n.RUnlock()
+	for i := len(n.publishMw) - 1; i >= 0; i-- {
+		h = n.publishMw[i](h)
+	}
+	return h
+}
+
+func (n *Node) receiveChain(h ReceiveHandler) ReceiveHandler {
+	n.RLock()
+	defer n.RUnlock()
+	for i := len(n.receiveMw) - 1; i >= 0; i-- {
+		h = n.receiveMw[i](h)
+	}
+	return h
+}
+
+// LogPublish logs every sent event at debug level, and failures at error level
+func LogPublish(l *zap.SugaredLogger) PublishMiddleware {
+	return func(next PublishHandler) PublishHandler {
+		return func(path string, ev *Event) error {
+			err := next(path, ev)
+			if err != nil {
+				l.Errorw("error sending event", "path", path, "trace_id", ev.TraceID, "span_id", ev.SpanID, "error", err)
+			} else {
+				l.Debugw("sent event", "path", path, "trace_id", ev.TraceID, "span_id", ev.SpanID, "size", len(ev.Body))
+			}
+			return err
+		}
+	}
+}
+
+// LogReceive logs every received event at debug level
+func LogReceive(l *zap.SugaredLogger) ReceiveMiddleware {
+	return func(next ReceiveHandler) ReceiveHandler {
+		return func(topic string, ev *Event) error {
+			l.Debugw("received event", "topic", topic, "node", ev.NodeName, "trace_id", ev.TraceID, "span_id", ev.SpanID, "size", len(ev.Body))
+			return next(topic, ev)
+		}
+	}
+}
+
+// RecoverPublish turns panics in later middlewares into errors
+func RecoverPublish() PublishMiddleware {
+	return func(next PublishHandler) PublishHandler {
+		return func(path string, ev *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic while sending event to [%s]: %v\n%s", path, r, debug.Stack())
+				}
+			}()
+			return next(path, ev)
+		}
+	}
+}
+
+// RecoverReceive turns panics in later middlewares into errors, so they do not kill the receive loop
+func RecoverReceive() ReceiveMiddleware {
+	return func(next ReceiveHandler) ReceiveHandler {
+		return func(topic string, ev *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic while receiving event on [%s]: %v\n%s", topic, r, debug.Stack())
+				}
+			}()
+			return next(topic, ev)
+		}
+	}
+}
+
+// StampHeaders sets headers on every sent event. Headers already present in the event are not overwritten
+func StampHeaders(headers map[string]any) PublishMiddleware {
+	return func(next PublishHandler) PublishHandler {
+		return func(path string, ev *Event) error {
+			// copy, so caller's map is not modified
+			h := make(map[string]any, len(ev.Headers)+len(headers))
+			for k, v := range headers {
+				h[k] = v
+			}
+			for k, v := range ev.Headers {
+				h[k] = v
+			}
+			ev.Headers = h
+			return next(path, ev)
+		}
+	}
+}
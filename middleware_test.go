@@ -0,0 +1,89 @@
+package zerosvc
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"testing"
+)
+
+func TestPublishMiddleware(t *testing.T) {
+	node := getTestDummyNode(t)
+	var order []string
+	var sent *Event
+	tag := func(name string) PublishMiddleware {
+		return func(next PublishHandler) PublishHandler {
+			return func(path string, ev *Event) error {
+				order = append(order, name)
+				return next(path, ev)
+			}
+		}
+	}
+	node.UsePublish(
+		LogPublish(zaptest.NewLogger(t).Sugar()),
+		RecoverPublish(),
+		tag("first"),
+		StampHeaders(map[string]any{"env": "test", "flavour": "default"}),
+	)
+	node.UsePublish(tag("second"))
+	require.NoError(t, node.SendEvent("cakes", node.NewEvent()))
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	// capture instead of sending
+	node.UsePublish(func(next PublishHandler) PublishHandler {
+		return func(path string, ev *Event) error {
+			sent = ev
+			return nil
+		}
+	})
+	ev := node.NewEvent()
+	ev.Headers["flavour"] = "chocolate"
+	require.NoError(t, node.SendEvent("cakes", ev))
+	require.NotNil(t, sent)
+	assert.Equal(t, "test", sent.Headers["env"])
+	assert.Equal(t, "chocolate", sent.Headers["flavour"])
+	assert.NotContains(t, ev.Headers, "env", "caller's headers should not be modified")
+	assert.False(t, sent.TS.IsZero())
+
+	t.Run("recover", func(t *testing.T) {
+		node := getTestDummyNode(t)
+		node.UsePublish(RecoverPublish(), func(next PublishHandler) PublishHandler {
+			return func(path string, ev *Event) error {
+				panic("no cake")
+			}
+		})
+		err := node.SendEvent("cakes", node.NewEvent())
+		assert.ErrorContains(t, err, "no cake")
+	})
+}
+
+func TestReceiveMiddleware(t *testing.T) {
+	node := getTestDummyNode(t)
+	var delivered []string
+	deliver := func(topic string, ev *Event) error {
+		delivered = append(delivered, topic)
+		return nil
+	}
+	errNotAllowed := errors.New("not allowed")
+	node.UseReceive(
+		LogReceive(zaptest.NewLogger(t).Sugar()),
+		RecoverReceive(),
+		func(next ReceiveHandler) ReceiveHandler {
+			return func(topic string, ev *Event) error {
+				if topic == "secret" {
+					return errNotAllowed
+				}
+				if topic == "explode" {
+					panic("boom")
+				}
+				return next(topic, ev)
+			}
+		},
+	)
+	ev := node.NewEvent()
+	assert.NoError(t, node.receiveChain(deliver)("cakes", &ev))
+	assert.ErrorIs(t, node.receiveChain(deliver)("secret", &ev), errNotAllowed)
+	assert.ErrorContains(t, node.receiveChain(deliver)("explode", &ev), "boom")
+	assert.Equal(t, []string{"cakes"}, delivered)
+}
@@ -1,7 +1,9 @@
 package zerosvc
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	g "github.com/XANi/goneric"
@@ -10,6 +12,7 @@ import (
 	"go.uber.org/zap"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,7 +34,26 @@ type Node struct {
 	e                 Encoder
 	d                 Decoder
 	autoTrace         bool
-	l                 *zap.SugaredLogger
+	envelopeVersion   atomic.Uint32
+	// compression settings
+	compression          Compression
+	compressionThreshold int
+	maxDecompressedSize  int
+	// encryption keys
+	encKey      *ecdh.PrivateKey
+	groupKeys   map[string][]byte
+	topicKeys   map[string]string
+	replayGuard *ReplayGuard
+	dedupe      *Deduper
+	errorHook   func(err error)
+	publishMw   []PublishMiddleware
+	receiveMw   []ReceiveMiddleware
+	// defaults for GetEventsCh
+	subscriptionDefaults SubscriptionOptions
+	deadLetterPath       string
+	l                    *zap.SugaredLogger
+	// subscriptionSeq numbers subscriptions, for per-subscription state in shared replayGuard
+	subscriptionSeq atomic.Uint64
 }
 
 type NodeInfo struct {
@@ -40,6 +62,10 @@ type NodeInfo struct {
 	TS        time.Time          `json:"ts" cbor:"ts"`
 	PublicKey string             `json:"pub,omitempty" cbor:"pub,omitempty"`
 	Services  map[string]Service `json:"services,omitempty" cbor:"services,omitempty"`
+	// Envelope is newest envelope version node can decode
+	Envelope uint8 `json:"envelope,omitempty" cbor:"envelope,omitempty"`
+	// EncryptionKey is X25519 public key that can be used to encrypt events to the node
+	EncryptionKey string `json:"enc,omitempty" cbor:"enc,omitempty"`
 }
 
 func NewNode(config Config) (*Node, error) {
@@ -47,16 +73,39 @@ func NewNode(config Config) (*Node, error) {
 		config.HeartbeatInterval = time.Minute * 5
 	}
 	n := Node{
-		Name:              config.NodeName,
-		UUID:              config.NodeUUID,
-		Services:          map[string]Service{},
-		l:                 config.Logger,
-		eventRoot:         config.EventRoot,
-		e:                 config.Encoder,
-		d:                 config.Decoder,
-		heartbeatInterval: config.HeartbeatInterval,
-		heartbeatEnabled:  true,
-		autoTrace:         true,
+		Name:                 config.NodeName,
+		UUID:                 config.NodeUUID,
+		Services:             map[string]Service{},
+		Signer:               config.Signer,
+		l:                    config.Logger,
+		eventRoot:            config.EventRoot,
+		e:                    config.Encoder,
+		d:                    config.Decoder,
+		heartbeatInterval:    config.HeartbeatInterval,
+		heartbeatEnabled:     true,
+		autoTrace:            true,
+		compression:          config.Compression,
+		compressionThreshold: config.CompressionThreshold,
+		maxDecompressedSize:  config.MaxDecompressedSize,
+		encKey:               config.EncryptionKey,
+		replayGuard:          config.ReplayGuard,
+		dedupe:               config.Dedupe,
+		errorHook:            config.ErrorHook,
+		subscriptionDefaults: config.SubscriptionDefaults,
+		deadLetterPath:       strings.Trim(config.DeadLetterPath, "/"),
+	}
+	n.envelopeVersion.Store(uint32(EnvelopeLatest))
+	if config.LegacyEnvelope {
+		n.envelopeVersion.Store(uint32(EnvelopeV0))
+	}
+	if n.compression == CompressionDefault {
+		n.compression = CompressionNone
+	}
+	if n.compressionThreshold <= 0 {
+		n.compressionThreshold = DefaultCompressionThreshold
+	}
+	if n.maxDecompressedSize <= 0 {
+		n.maxDecompressedSize = DefaultMaxDecompressedSize
 	}
 	if n.l == nil {
 		n.l = zap.NewNop().Sugar()
@@ -113,7 +162,7 @@ func (n *Node) NewEvent(traceSpanId ...[]byte) Event {
 	} else if n.autoTrace {
 		ev.TraceID = make([]byte, 16)
 		g.Must(rand.Read(ev.TraceID))
-		ev.TraceID = make([]byte, 8)
+		ev.SpanID = make([]byte, 8)
 		g.Must(rand.Read(ev.SpanID))
 	}
 	return ev
@@ -137,7 +186,20 @@ func (n *Node) PrepareReply(ev Event) Event {
 	return reply
 }
 
+// SendEvent sends event to path under event root, thru publish middlewares. Event's TS is set to current time if empty
 func (n *Node) SendEvent(path string, ev Event) error {
+	if ev.TS.IsZero() {
+		ev.TS = time.Now()
+	}
+	return n.publishChain(n.sendEvent)(path, &ev)
+}
+
+func (n *Node) sendEvent(path string, ev *Event) error {
+	if ev.encryptTo == nil {
+		if keyID, found := n.topicKeyFor(path); found {
+			ev.EncryptForGroup(keyID)
+		}
+	}
 	data, err := ev.Serialize()
 	if err != nil {
 		return err
@@ -155,6 +217,41 @@ func (n *Node) SendEvent(path string, ev Event) error {
 	)
 }
 
+// clearRetained removes retained event from path under event root
+func (n *Node) clearRetained(path string) error {
+	return n.tr.Publish(Message{
+		Topic:   n.eventRoot + "/" + path,
+		Payload: []byte{},
+		Retain:  true,
+	})
+}
+
+// EventRoot returns topic prefix all node's paths are relative to
+func (n *Node) EventRoot() string {
+	return n.eventRoot
+}
+
+// needsVersionedEnvelope returns true if node by default sends events that can't use EnvelopeV0
+func (n *Node) needsVersionedEnvelope() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return n.compression != CompressionNone || len(n.topicKeys) > 0
+}
+
+// EnvelopeVersion returns envelope version used for outgoing events
+func (n *Node) EnvelopeVersion() uint8 {
+	return uint8(n.envelopeVersion.Load())
+}
+
+// SetEnvelopeVersion changes envelope version used for outgoing events, see DiscoveryConfig.NegotiateEnvelope
+func (n *Node) SetEnvelopeVersion(v uint8) error {
+	if v > EnvelopeLatest {
+		return fmt.Errorf("unsupported envelope version %d", v)
+	}
+	n.envelopeVersion.Store(uint32(v))
+	return nil
+}
+
 func (n *Node) Heartbeat() {
 	m := Message{
 		ContentType: "application/json",
@@ -166,6 +263,13 @@ func (n *Node) Heartbeat() {
 		UUID:     n.UUID,
 		TS:       time.Now().Truncate(time.Second),
 		Services: n.Services,
+		Envelope: EnvelopeLatest,
+	}
+	if n.Signer != nil {
+		v.PublicKey = base64.StdEncoding.EncodeToString(n.Signer.PublicKey())
+	}
+	if k := n.encryptionKey(); k != nil {
+		v.EncryptionKey = base64.StdEncoding.EncodeToString(k.PublicKey().Bytes())
 	}
 	d, _ := json.Marshal(v)
 	n.RUnlock()
@@ -177,30 +281,75 @@ func (n *Node) Heartbeat() {
 	}
 }
 
+// reportError passes error to error hook or logs it if there is none
+func (n *Node) reportError(err error) {
+	if n.errorHook != nil {
+		n.errorHook(err)
+	} else {
+		n.l.Errorf("%s", err)
+	}
+}
+
+// compressionFor returns compression that should be used for event with serialized size of size
+func (n *Node) compressionFor(ev *Event, size int) Compression {
+	if ev.compression != CompressionDefault {
+		return ev.compression
+	}
+	if size < n.compressionThreshold {
+		return CompressionNone
+	}
+	return n.compression
+}
+
+// verifierFor returns verifier for event's sender, or nil if there is no key to verify it with
+func (n *Node) verifierFor(ev *Event) Verifier {
+	if n.PubkeyRetriever != nil {
+		if v, found := n.PubkeyRetriever(ev.NodeName, ev.NodeUUID); found {
+			return v
+		}
+	}
+	if n.Signer != nil {
+		return n.Signer
+	}
+	return nil
+}
+
+// GetEventsCh returns channel of events matching the filter, with buffer and overflow policy from node config.
+// Use GetSubscription() to set them per subscription
 func (n *Node) GetEventsCh(filter string) (chan Event, error) {
-	ch := make(chan Event, 1)
-	messages := make(chan *Message, 1)
-	err := n.tr.Subscribe(n.eventRoot+"/"+filter, messages)
+	s, err := n.GetSubscription(filter)
 	if err != nil {
 		return nil, err
 	}
-	go func() {
-		for m := range messages {
-			ev := &Event{}
-			ev, err := ev.Deserialize(m.Payload, n)
-			if err != nil {
-				n.l.Errorf("error unmarshalling payload [%s]: %s", m.Topic, err)
-				continue
-			}
-			ch <- *ev
-		}
-	}()
+	return s.Events, nil
+}
+
+// GetDurableEventsCh returns channel of events matching the filter, using persistent session identified by consumerName.
+// See GetDurableSubscription()
+func (n *Node) GetDurableEventsCh(filter string, consumerName string) (chan Event, error) {
+	s, err := n.GetDurableSubscription(filter, consumerName)
+	if err != nil {
+		return nil, err
+	}
+	return s.Events, nil
+}
 
-	return ch, nil
+// GetEventsChGroup returns channel of events matching the filter, shared with other members of the group.
+// See GetGroupSubscription()
+func (n *Node) GetEventsChGroup(filter string, group string) (chan Event, error) {
+	s, err := n.GetGroupSubscription(filter, group)
+	if err != nil {
+		return nil, err
+	}
+	return s.Events, nil
 }
 
-// GetReplyChan() returns randomly generated channel for replies
+// GetReplyChan() subscribes to randomly generated reply topic. Returned path is full topic, to be used as ReplyTo
 func (n *Node) GetReplyChan() (path string, replyCh chan Event, err error) {
-	path = n.eventRoot + "/reply/" + n.Name + "/" + mapBytesToTopicTitle(rngBlob(8))
-	return
+	relPath := "reply/" + n.Name + "/" + mapBytesToTopicTitle(rngBlob(8))
+	replyCh, err = n.GetEventsCh(relPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return n.eventRoot + "/" + relPath, replyCh, nil
 }
@@ -68,3 +68,98 @@ func TestNodeComms(t *testing.T) {
 		assert.Equal(t, []byte("cake"), ev.Body)
 	}
 }
+
+func TestNodeDurableEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		root  string
+		newTr func(id string) (Transport, func())
+	}{
+		{"v3", "durable", func(id string) (Transport, func()) {
+			tr, err := NewTransportMQTTv3(ConfigMQTTv3{ID: id, MQTTURL: []*url.URL{getTestMQURL()}})
+			require.NoError(t, err)
+			return tr, tr.Disconnect
+		}},
+		{"v5", "durable5", func(id string) (Transport, func()) {
+			tr, err := NewTransportMQTTv5(ConfigMQTTv5{ID: id, MQTTURL: []*url.URL{getTestMQURL()}})
+			require.NoError(t, err)
+			return tr, func() { tr.Disconnect() }
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newNode := func(id string) (*Node, func()) {
+				tr, disconnect := tc.newTr(id)
+				n, err := NewNode(Config{
+					NodeName:  "node-" + id,
+					Transport: tr,
+					EventRoot: "test",
+				})
+				require.NoError(t, err)
+				return n, disconnect
+			}
+			id := "TestNodeDurableEvents-" + tc.name
+			pub, pubDisconnect := newNode(id + "-pub")
+			defer pubDisconnect()
+			consumer, consumerDisconnect := newNode(id + "-sub")
+			_, err := consumer.GetDurableEventsCh(tc.root+"/#", "cake-eater")
+			require.NoError(t, err)
+			consumerDisconnect()
+
+			ev := pub.NewEvent()
+			ev.Body = []byte("cake")
+			require.NoError(t, pub.SendEvent(tc.root+"/cake", ev))
+
+			consumer, consumerDisconnect = newNode(id + "-sub")
+			defer consumerDisconnect()
+			evCh, err := consumer.GetDurableEventsCh(tc.root+"/#", "cake-eater")
+			require.NoError(t, err)
+			select {
+			case <-time.After(time.Second * 10):
+				assert.True(t, false, "receiving message sent while offline timed out")
+			case ev := <-evCh:
+				assert.Equal(t, []byte("cake"), ev.Body)
+			}
+		})
+	}
+}
+
+func TestNodeGetReplyChan(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string) *Node {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n
+	}
+	caller := newNode("caller")
+	responder := newNode("responder")
+	path, replies, err := caller.GetReplyChan()
+	require.NoError(t, err)
+	require.NotNil(t, replies)
+	assert.Regexp(t, "^test/reply/caller/", path)
+
+	reply := responder.NewEvent()
+	reply.Body = []byte("pong")
+	require.NoError(t, responder.SendEvent(path[len("test/"):], reply))
+	select {
+	case ev := <-replies:
+		assert.Equal(t, []byte("pong"), ev.Body)
+		assert.Equal(t, path, ev.Topic)
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+}
+
+func TestNodeConfigSigner(t *testing.T) {
+	signer, err := NewSignerEd25519()
+	require.NoError(t, err)
+	node := getTestDummyNode(t, Config{Signer: signer})
+	assert.Equal(t, signer, node.Signer)
+	ev := node.NewEvent()
+	data, err := ev.Serialize()
+	require.NoError(t, err)
+	out, err := (&Event{}).Deserialize(data, node)
+	require.NoError(t, err)
+	assert.NotEmpty(t, out.Signature, "events should be signed with signer from config")
+}
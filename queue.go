@@ -0,0 +1,214 @@
+package zerosvc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQueueMaxAttempts    = 5
+	DefaultQueueInitialBackoff = time.Second
+	DefaultQueueMaxBackoff     = time.Minute * 5
+)
+
+// Job headers
+const (
+	HeaderQueueAttempt = "_queue_attempt"
+	HeaderQueueError   = "_queue_error"
+	HeaderQueueOrigin  = "_queue_origin"
+	HeaderQueueName    = "_queue_name"
+)
+
+type QueueConfig struct {
+	Node *Node
+	// Name of the queue. Jobs are sent to queue/<name> under node's event root
+	Name string
+	// MaxAttempts before job goes to dead-letter topic, DefaultQueueMaxAttempts if 0
+	MaxAttempts int
+	// InitialBackoff before first retry, doubled on each next one; DefaultQueueInitialBackoff if 0
+	InitialBackoff time.Duration
+	// MaxBackoff caps the retry delay, DefaultQueueMaxBackoff if 0
+	MaxBackoff time.Duration
+	// DeadLetterPath is where exhausted jobs are sent, relative to event root. deadletter/queue/<name> if empty
+	DeadLetterPath string
+	// Concurrency is number of jobs handled in parallel by Consume, 1 if 0
+	Concurrency int
+	// AllowUnsharedFallback makes Consume use normal subscription if transport does not support shared ones,
+	// instead of failing. Every consumer then gets every job
+	AllowUnsharedFallback bool
+}
+
+// Queue is a job queue on top of events. Consumers of the same queue share jobs
+// via shared subscription, so transport has to support it (see QueueConfig.AllowUnsharedFallback).
+//
+// Retries are driven by the consumer: nacked job is re-sent to the queue after backoff,
+// so job being delayed is lost if the consumer dies before re-sending it
+type Queue struct {
+	cfg  QueueConfig
+	path string
+	n    *Node
+}
+
+// Job is a single queued event. Handler should call Ack() or Nack(); job that was neither is nacked
+type Job struct {
+	Event
+	// Attempt number, starting from 1
+	Attempt int
+	q       *Queue
+	done    bool
+	sync.Mutex
+}
+
+func NewQueue(cfg QueueConfig) (*Queue, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if len(cfg.Name) == 0 {
+		return nil, fmt.Errorf("queue name required")
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultQueueMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = DefaultQueueInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultQueueMaxBackoff
+	}
+	if len(cfg.DeadLetterPath) == 0 {
+		cfg.DeadLetterPath = "deadletter/queue/" + cfg.Name
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	return &Queue{
+		cfg:  cfg,
+		path: "queue/" + cfg.Name,
+		n:    cfg.Node,
+	}, nil
+}
+
+// Enqueue sends job to the queue
+func (q *Queue) Enqueue(job Event) error {
+	h := make(map[string]any, len(job.Headers)+2)
+	for k, v := range job.Headers {
+		h[k] = v
+	}
+	h[HeaderQueueAttempt] = 1
+	h[HeaderQueueName] = q.cfg.Name
+	job.Headers = h
+	return q.n.SendEvent(q.path, job)
+}
+
+// Consume starts handling jobs in background
+func (q *Queue) Consume(handler func(job *Job)) error {
+	s, err := q.n.GetGroupSubscription(q.path, "queue-"+q.cfg.Name)
+	if errors.Is(err, ErrSharedSubscriptionUnsupported{}) && q.cfg.AllowUnsharedFallback {
+		q.n.l.Warnf("queue %s: %s, falling back to normal subscription; every consumer will get every job", q.cfg.Name, err)
+		s, err = q.n.GetSubscription(q.path)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("queue %s: %w", q.cfg.Name, err)
+	}
+	for i := 0; i < q.cfg.Concurrency; i++ {
+		go func() {
+			for ev := range s.Events {
+				job := &Job{
+					Event:   ev,
+					Attempt: headerInt(ev.Headers, HeaderQueueAttempt),
+					q:       q,
+				}
+				if job.Attempt < 1 {
+					job.Attempt = 1
+				}
+				q.handle(handler, job)
+			}
+		}()
+	}
+	return nil
+}
+
+func (q *Queue) handle(handler func(job *Job), job *Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			job.Nack(fmt.Errorf("handler panicked: %v", r))
+		}
+	}()
+	handler(job)
+	job.Nack(fmt.Errorf("job not acknowledged"))
+}
+
+// Ack marks job as done
+func (j *Job) Ack() {
+	j.Lock()
+	defer j.Unlock()
+	j.done = true
+}
+
+// Nack marks job as failed; it will be retried after backoff or sent to dead-letter topic if out of attempts
+func (j *Job) Nack(reason error) {
+	j.Lock()
+	defer j.Unlock()
+	if j.done {
+		return
+	}
+	j.done = true
+	q := j.q
+	retry := q.n.NewEvent(j.TraceID)
+	retry.Body = j.Body
+	retry.ReplyTo = j.ReplyTo
+	for k, v := range j.Headers {
+		retry.Headers[k] = v
+	}
+	if _, ok := retry.Headers[HeaderQueueOrigin]; !ok {
+		retry.Headers[HeaderQueueOrigin] = j.NodeName
+	}
+	if reason != nil {
+		retry.Headers[HeaderQueueError] = reason.Error()
+	}
+	if j.Attempt >= q.cfg.MaxAttempts {
+		q.n.l.Warnf("queue %s: job failed %d times, sending to %s: %s", q.cfg.Name, j.Attempt, q.cfg.DeadLetterPath, reason)
+		if err := q.n.SendEvent(q.cfg.DeadLetterPath, retry); err != nil {
+			q.n.reportError(fmt.Errorf("queue %s: error sending job to dead-letter: %w", q.cfg.Name, err))
+		}
+		return
+	}
+	retry.Headers[HeaderQueueAttempt] = j.Attempt + 1
+	time.AfterFunc(q.backoff(j.Attempt), func() {
+		if err := q.n.SendEvent(q.path, retry); err != nil {
+			q.n.reportError(fmt.Errorf("queue %s: error re-sending job: %w", q.cfg.Name, err))
+		}
+	})
+}
+
+// backoff returns delay before retrying job that failed given attempt
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.cfg.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= q.cfg.MaxBackoff {
+			return q.cfg.MaxBackoff
+		}
+	}
+	return d
+}
+
+// headerInt returns integer header regardless of which integer type decoder picked
+func headerInt(h map[string]any, key string) int {
+	switch v := h[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
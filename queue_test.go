@@ -0,0 +1,148 @@
+package zerosvc
+
+import (
+	"fmt"
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string) *Node {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n
+	}
+	client := newNode("client")
+	worker := newNode("worker")
+
+	_, err := NewQueue(QueueConfig{Node: client})
+	assert.Error(t, err)
+
+	cfg := QueueConfig{
+		Name:           "bake",
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	}
+	cfg.Node = worker
+	wq, err := NewQueue(cfg)
+	require.NoError(t, err)
+	cfg.Node = client
+	cq, err := NewQueue(cfg)
+	require.NoError(t, err)
+
+	deadletter, err := client.GetEventsCh("deadletter/queue/bake")
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	attempts := make(chan int, 16)
+	require.NoError(t, wq.Consume(func(job *Job) {
+		calls.Add(1)
+		attempts <- job.Attempt
+		switch string(job.Body) {
+		case "good":
+			job.Ack()
+		case "flaky":
+			if job.Attempt < 2 {
+				job.Nack(fmt.Errorf("oven cold"))
+			} else {
+				job.Ack()
+			}
+		case "panic":
+			panic("oven exploded")
+		default:
+			job.Nack(fmt.Errorf("burnt"))
+		}
+	}))
+
+	enqueue := func(body string) {
+		ev := client.NewEvent()
+		ev.Body = []byte(body)
+		require.NoError(t, cq.Enqueue(ev))
+	}
+	enqueue("good")
+	assert.Equal(t, []int{1}, goneric.ChanToSliceNTimeout(attempts, 1, time.Second))
+	enqueue("flaky")
+	assert.Equal(t, []int{1, 2}, goneric.ChanToSliceNTimeout(attempts, 2, time.Second))
+
+	enqueue("bad")
+	assert.Equal(t, []int{1, 2, 3}, goneric.ChanToSliceNTimeout(attempts, 3, time.Second))
+	dl := goneric.ChanToSliceNTimeout(deadletter, 1, time.Second)
+	require.Len(t, dl, 1)
+	assert.Equal(t, []byte("bad"), dl[0].Body)
+	assert.Equal(t, "burnt", dl[0].Headers[HeaderQueueError])
+	assert.Equal(t, "client", dl[0].Headers[HeaderQueueOrigin])
+
+	enqueue("panic")
+	assert.Equal(t, []int{1, 2, 3}, goneric.ChanToSliceNTimeout(attempts, 3, time.Second))
+	dl = goneric.ChanToSliceNTimeout(deadletter, 1, time.Second)
+	require.Len(t, dl, 1)
+	assert.Contains(t, dl[0].Headers[HeaderQueueError], "oven exploded")
+	assert.Equal(t, int32(9), calls.Load())
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q, err := NewQueue(QueueConfig{
+		Node:           getTestDummyNode(t),
+		Name:           "test",
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second * 5,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, q.backoff(1))
+	assert.Equal(t, time.Second*2, q.backoff(2))
+	assert.Equal(t, time.Second*4, q.backoff(3))
+	assert.Equal(t, time.Second*5, q.backoff(4))
+	assert.Equal(t, time.Second*5, q.backoff(40))
+}
+
+// testSharedTransport fails shared subscriptions with err
+type testSharedTransport struct {
+	Transport
+	err error
+}
+
+func (t *testSharedTransport) SubscribeShared(topic string, group string, data chan *Message) error {
+	return t.err
+}
+
+func TestQueueUnsharedFallback(t *testing.T) {
+	newQueue := func(tr Transport, fallback bool) *Queue {
+		n, err := NewNode(Config{NodeName: "worker", Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		q, err := NewQueue(QueueConfig{Node: n, Name: "bake", AllowUnsharedFallback: fallback})
+		require.NoError(t, err)
+		return q
+	}
+	newTransport := func() Transport {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: NewMemoryBroker()})
+		require.NoError(t, err)
+		return tr
+	}
+	// anonymous struct hides SubscribeShared of the memory transport
+	err := newQueue(struct{ Transport }{newTransport()}, false).Consume(func(job *Job) { job.Ack() })
+	assert.ErrorIs(t, err, ErrSharedSubscriptionUnsupported{})
+
+	q := newQueue(struct{ Transport }{newTransport()}, true)
+	jobs := make(chan *Job, 1)
+	require.NoError(t, q.Consume(func(job *Job) {
+		job.Ack()
+		jobs <- job
+	}))
+	ev := q.n.NewEvent()
+	ev.Body = []byte("cake")
+	require.NoError(t, q.Enqueue(ev))
+	got := goneric.ChanToSliceNTimeout(jobs, 1, time.Second)
+	require.Len(t, got, 1)
+	assert.Equal(t, []byte("cake"), got[0].Body)
+
+	broken := &testSharedTransport{Transport: newTransport(), err: fmt.Errorf("not authorized")}
+	err = newQueue(broken, true).Consume(func(job *Job) { job.Ack() })
+	assert.ErrorContains(t, err, "not authorized", "only unsupported shared subscription should fall back")
+}
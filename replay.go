@@ -0,0 +1,140 @@
+package zerosvc
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultReplayMaxSkew   = time.Minute * 5
+	DefaultReplayCacheSize = 10000
+)
+
+type ReplayGuardConfig struct {
+	// MaxSkew is how far event timestamp can be from local clock, in either direction. DefaultReplayMaxSkew if not set
+	MaxSkew time.Duration
+	// CacheSize is number of recently seen events remembered. DefaultReplayCacheSize if not set.
+	// It should be big enough to hold all events arriving within 2*MaxSkew, else old replays will not be detected
+	CacheSize int
+	// AllowUnstamped accepts events without timestamp (sent by old nodes). Those only go thru duplicate check
+	AllowUnstamped bool
+}
+
+// ReplayGuard rejects events outside of clock skew window and events that were already seen.
+// Events are identified by (NodeUUID, SpanID), events without SpanID are only checked for timestamp.
+// Seen events are tracked per scope (node uses one per subscription), so event matching two subscriptions
+// is delivered to both. Retained events are not checked for skew, as they can be legitimately old.
+// Rejected events are only counted (see Rejected), not dead-lettered, as replays and redeliveries are expected.
+// Guard only makes sense with signed events, else timestamp and span can be just rewritten.
+type ReplayGuard struct {
+	cfg      ReplayGuardConfig
+	seen     *seenCache
+	now      func() time.Time
+	rejected atomic.Uint64
+}
+
+type ErrEventReplayed struct{}
+
+func (e ErrEventReplayed) Error() string {
+	return "event already seen"
+}
+
+type ErrClockSkew struct {
+	Skew time.Duration
+}
+
+func (e ErrClockSkew) Error() string {
+	return fmt.Sprintf("event timestamp outside of allowed window [%s]", e.Skew)
+}
+
+func NewReplayGuard(cfg ReplayGuardConfig) *ReplayGuard {
+	if cfg.MaxSkew <= 0 {
+		cfg.MaxSkew = DefaultReplayMaxSkew
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = DefaultReplayCacheSize
+	}
+	return &ReplayGuard{
+		cfg:  cfg,
+		seen: newSeenCache(cfg.CacheSize, cfg.MaxSkew*2),
+		now:  time.Now,
+	}
+}
+
+// Rejected returns number of events rejected by Check
+func (g *ReplayGuard) Rejected() uint64 {
+	return g.rejected.Load()
+}
+
+// Check returns error if event should be dropped. Event is remembered as seen in given scope.
+func (g *ReplayGuard) Check(scope string, ev *Event) error {
+	err := g.check(scope, ev)
+	if err != nil {
+		g.rejected.Add(1)
+	}
+	return err
+}
+
+func (g *ReplayGuard) check(scope string, ev *Event) error {
+	now := g.now()
+	if ev.TS.IsZero() {
+		if !g.cfg.AllowUnstamped {
+			return fmt.Errorf("event has no timestamp")
+		}
+	} else if !ev.Retained {
+		skew := ev.TS.Sub(now)
+		if skew > g.cfg.MaxSkew || skew < -g.cfg.MaxSkew {
+			return ErrClockSkew{Skew: skew}
+		}
+	}
+	if len(ev.SpanID) == 0 {
+		return nil
+	}
+	if g.seen.checkAndAdd(scope+"\000"+ev.NodeUUID+"\000"+string(ev.SpanID), now) {
+		return ErrEventReplayed{}
+	}
+	return nil
+}
+
+// seenCache remembers keys up to size entries or ttl age, whichever is hit first
+type seenCache struct {
+	sync.Mutex
+	size  int
+	ttl   time.Duration
+	keys  map[string]time.Time
+	order []string
+	head  int
+}
+
+func newSeenCache(size int, ttl time.Duration) *seenCache {
+	return &seenCache{
+		size:  size,
+		ttl:   ttl,
+		keys:  make(map[string]time.Time, size),
+		order: make([]string, 0, size),
+	}
+}
+
+// checkAndAdd returns true if key was seen within TTL, and marks it as seen
+func (c *seenCache) checkAndAdd(key string, now time.Time) (seen bool) {
+	c.Lock()
+	defer c.Unlock()
+	if ts, ok := c.keys[key]; ok && now.Sub(ts) < c.ttl {
+		return true
+	} else if ok {
+		// expired but still in ring; just refresh timestamp, the ring slot stays
+		c.keys[key] = now
+		return false
+	}
+	if len(c.order) < c.size {
+		c.order = append(c.order, key)
+	} else {
+		delete(c.keys, c.order[c.head])
+		c.order[c.head] = key
+		c.head = (c.head + 1) % c.size
+	}
+	c.keys[key] = now
+	return false
+}
@@ -0,0 +1,144 @@
+package zerosvc
+
+import (
+	"fmt"
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReplayGuard(t *testing.T) {
+	node := getTestDummyNode(t)
+	g := NewReplayGuard(ReplayGuardConfig{MaxSkew: time.Minute, CacheSize: 4})
+	now := time.Now()
+	g.now = func() time.Time { return now }
+
+	ev := node.NewEvent()
+	require.NotEmpty(t, ev.SpanID)
+	ev.TS = now
+	assert.NoError(t, g.Check("s", &ev))
+	assert.ErrorIs(t, g.Check("s", &ev), ErrEventReplayed{})
+
+	t.Run("skew", func(t *testing.T) {
+		ev := node.NewEvent()
+		ev.TS = now.Add(-time.Minute * 2)
+		assert.ErrorAs(t, g.Check("s", &ev), &ErrClockSkew{})
+		ev.TS = now.Add(time.Minute * 2)
+		assert.ErrorAs(t, g.Check("s", &ev), &ErrClockSkew{})
+		ev.TS = now.Add(time.Second * 30)
+		assert.NoError(t, g.Check("s", &ev))
+	})
+	t.Run("unstamped", func(t *testing.T) {
+		ev := node.NewEvent()
+		assert.Error(t, g.Check("s", &ev))
+		g2 := NewReplayGuard(ReplayGuardConfig{AllowUnstamped: true})
+		assert.NoError(t, g2.Check("s", &ev))
+		assert.ErrorIs(t, g2.Check("s", &ev), ErrEventReplayed{})
+	})
+	t.Run("no span", func(t *testing.T) {
+		ev := Event{TS: now}
+		assert.NoError(t, g.Check("s", &ev))
+		assert.NoError(t, g.Check("s", &ev))
+	})
+	t.Run("other node same span", func(t *testing.T) {
+		ev2 := ev
+		ev2.NodeUUID = "other"
+		assert.NoError(t, g.Check("s", &ev2))
+	})
+	t.Run("other scope", func(t *testing.T) {
+		assert.NoError(t, g.Check("other", &ev), "scopes should not share seen events")
+		assert.ErrorIs(t, g.Check("other", &ev), ErrEventReplayed{})
+	})
+	t.Run("retained", func(t *testing.T) {
+		ev := node.NewEvent()
+		ev.TS = now.Add(-time.Hour)
+		ev.Retained = true
+		assert.NoError(t, g.Check("s", &ev), "retained event can be older than skew")
+		assert.ErrorIs(t, g.Check("s", &ev), ErrEventReplayed{})
+	})
+}
+
+func TestReplayGuardSubscriptions(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string, guard *ReplayGuard) *Node {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test", ReplayGuard: guard})
+		require.NoError(t, err)
+		return n
+	}
+	sender := newNode("sender", nil)
+	receiver := newNode("receiver", NewReplayGuard(ReplayGuardConfig{MaxSkew: time.Minute}))
+
+	old := sender.NewEvent()
+	old.TS = time.Now().Add(-time.Hour)
+	old.Body = []byte("config")
+	old.SetRetain(true)
+	require.NoError(t, sender.SendEvent("config/oven", old))
+
+	all, err := receiver.GetEventsCh("#")
+	require.NoError(t, err)
+	config, err := receiver.GetEventsCh("config/#")
+	require.NoError(t, err)
+	for _, ch := range []chan Event{all, config} {
+		got := goneric.ChanToSliceNTimeout(ch, 1, time.Second)
+		require.Len(t, got, 1, "old retained event should pass the guard")
+		assert.True(t, got[0].Retained)
+	}
+
+	ev := sender.NewEvent()
+	ev.Body = []byte("live")
+	require.NoError(t, sender.SendEvent("config/temp", ev))
+	for _, ch := range []chan Event{all, config} {
+		got := goneric.ChanToSliceNTimeout(ch, 1, time.Second)
+		require.Len(t, got, 1, "event matching both subscriptions should reach both")
+		assert.Equal(t, []byte("live"), got[0].Body)
+	}
+}
+
+func TestReplayGuardNotDeadLettered(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	sender, err := NewNode(Config{NodeName: "sender", Transport: tr, EventRoot: "test"})
+	require.NoError(t, err)
+	tr, err = NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	guard := NewReplayGuard(ReplayGuardConfig{MaxSkew: time.Minute})
+	receiver, err := NewNode(Config{NodeName: "receiver", Transport: tr, EventRoot: "test", ReplayGuard: guard, DeadLetterPath: "deadletter"})
+	require.NoError(t, err)
+	dl, err := sender.GetEventsCh("deadletter/#")
+	require.NoError(t, err)
+	ch, err := receiver.GetEventsCh("cakes/#")
+	require.NoError(t, err)
+
+	ev := sender.NewEvent()
+	require.NoError(t, sender.SendEvent("cakes/cake", ev))
+	// same event again
+	require.NoError(t, sender.SendEvent("cakes/cake", ev))
+	stale := sender.NewEvent()
+	stale.TS = time.Now().Add(-time.Hour)
+	require.NoError(t, sender.SendEvent("cakes/cake", stale))
+
+	assert.Len(t, goneric.ChanToSliceNTimeout(ch, 3, time.Millisecond*100), 1)
+	assert.Equal(t, uint64(2), guard.Rejected())
+	assert.Empty(t, goneric.ChanToSliceNTimeout(dl, 1, time.Millisecond*100), "rejected events should not be dead-lettered")
+}
+
+func TestSeenCache(t *testing.T) {
+	now := time.Now()
+	c := newSeenCache(3, time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.False(t, c.checkAndAdd(fmt.Sprint(i), now))
+	}
+	assert.True(t, c.checkAndAdd("0", now))
+	// evicts oldest
+	assert.False(t, c.checkAndAdd("3", now))
+	assert.False(t, c.checkAndAdd("0", now))
+	assert.Len(t, c.keys, 3)
+	// expires
+	assert.True(t, c.checkAndAdd("3", now.Add(time.Second)))
+	assert.False(t, c.checkAndAdd("3", now.Add(time.Minute*2)))
+}
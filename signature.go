@@ -3,6 +3,11 @@ package zerosvc
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"golang.org/x/crypto/ed25519"
 )
@@ -92,3 +97,61 @@ func (s *SigEd25519) Verify(data []byte, signature []byte) (ok bool) {
 func (s *SigEd25519) Type() uint8 {
 	return SigTypeEd25519
 }
+
+// PublicKeyFingerprint returns short ID of signing public key, for humans to compare keys
+func PublicKeyFingerprint(pub []byte) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:8])
+}
+
+// ParseEd25519Key decodes Ed25519 key in any supported form:
+//
+//   - PEM with PKCS#8 private key ("PRIVATE KEY") or PKIX public key ("PUBLIC KEY")
+//   - base64 of raw private key (64 bytes), its seed (32 bytes) or, if public is allowed, public key (32 bytes)
+//
+// priv is nil for public keys. As seed and public key have the same size, raw 32 bytes are treated as seed
+// if wantPrivate is set and as public key otherwise
+func ParseEd25519Key(data []byte, wantPrivate bool) (priv ed25519.PrivateKey, pub ed25519.PublicKey, err error) {
+	data = bytes.TrimSpace(data)
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing PKCS#8 key: %w", err)
+			}
+			priv, ok := k.(ed25519.PrivateKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("PKCS#8 key is %T, not Ed25519", k)
+			}
+			return priv, priv.Public().(ed25519.PublicKey), nil
+		case "PUBLIC KEY":
+			k, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing public key: %w", err)
+			}
+			pub, ok := k.(ed25519.PublicKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("public key is %T, not Ed25519", k)
+			}
+			return nil, pub, nil
+		default:
+			return nil, nil, fmt.Errorf("unsupported PEM block [%s]", block.Type)
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("key is neither PEM nor base64: %w", err)
+	}
+	switch {
+	case len(raw) == ed25519.PrivateKeySize:
+		priv = raw
+	case len(raw) == ed25519.SeedSize && wantPrivate:
+		priv = ed25519.NewKeyFromSeed(raw)
+	case len(raw) == ed25519.PublicKeySize:
+		return nil, raw, nil
+	default:
+		return nil, nil, fmt.Errorf("key has wrong size %d", len(raw))
+	}
+	return priv, priv.Public().(ed25519.PublicKey), nil
+}
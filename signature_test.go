@@ -22,6 +22,12 @@ func TestSigEd25519(t *testing.T) {
 		assert.False(t, sig.Verify(blob, signature1))
 		assert.False(t, sig.Verify(blob, signature2))
 	})
+	t.Run("fingerprint", func(t *testing.T) {
+		other, _ := NewSignerEd25519()
+		assert.Len(t, PublicKeyFingerprint(sig.PublicKey()), 16)
+		assert.Equal(t, PublicKeyFingerprint(sig.PublicKey()), PublicKeyFingerprint(sig.PublicKey()))
+		assert.NotEqual(t, PublicKeyFingerprint(sig.PublicKey()), PublicKeyFingerprint(other.PublicKey()))
+	})
 }
 
 func TestSignedEvent(t *testing.T) {
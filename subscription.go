@@ -0,0 +1,242 @@
+package zerosvc
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultEventBufferSize is size of the event channel if not configured
+const DefaultEventBufferSize = 64
+
+// DefaultMaxBacklog is limit of OverflowBlock subscription's backlog if not configured
+const DefaultMaxBacklog = 4096
+
+// OverflowPolicy decides what happens when subscriber does not keep up and its buffer is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock queues events consumer does not keep up with in memory (see Subscription.Backlog)
+	// so transport's receive loop, other subscriptions and keepalives are not stalled.
+	// Consumer stuck for good would grow the backlog without bound, so past MaxBacklog oldest queued events are dropped
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops oldest buffered event to make space for the new one
+	OverflowDropOldest
+	// OverflowDropNewest drops incoming event
+	OverflowDropNewest
+	// OverflowError drops incoming event and reports ErrSubscriptionOverflow to OnOverflow or node's ErrorHook
+	OverflowError
+)
+
+type SubscriptionOptions struct {
+	// BufferSize of event channel, DefaultEventBufferSize if 0
+	BufferSize int
+	// Overflow policy, OverflowBlock by default. No policy lets slow consumer stall other subscriptions
+	// or transport keepalives; they differ in what happens to events consumer does not keep up with
+	Overflow OverflowPolicy
+	// OnOverflow is called with ErrSubscriptionOverflow for every dropped event if policy is OverflowError
+	OnOverflow func(err error)
+	// MaxBacklog of OverflowBlock subscription, DefaultMaxBacklog if 0
+	MaxBacklog int
+}
+
+type ErrSubscriptionOverflow struct {
+	Filter string
+	Topic  string
+}
+
+func (e ErrSubscriptionOverflow) Error() string {
+	return fmt.Sprintf("subscription [%s] buffer full, dropped event on [%s]", e.Filter, e.Topic)
+}
+
+// Subscription is a stream of events matching the filter
+type Subscription struct {
+	// Events channel
+	Events  chan Event
+	Filter  string
+	opts    SubscriptionOptions
+	dropped atomic.Uint64
+	n       *Node
+	// backlog of OverflowBlock subscription, drained into Events by its own goroutine
+	backlog      []Event
+	backlogReady chan struct{}
+	backlogLock  sync.Mutex
+}
+
+// Dropped returns number of events dropped because of overflow, including ones over MaxBacklog
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Backlog returns number of events of OverflowBlock subscription waiting for space in Events
+func (s *Subscription) Backlog() int {
+	s.backlogLock.Lock()
+	defer s.backlogLock.Unlock()
+	return len(s.backlog)
+}
+
+// GetSubscription subscribes to the filter with given buffer and overflow settings.
+// Zero value options use defaults from node config.
+func (n *Node) GetSubscription(filter string, opts ...SubscriptionOptions) (*Subscription, error) {
+	return n.subscribe(filter, n.tr.Subscribe, opts...)
+}
+
+// GetDurableSubscription is GetSubscription using persistent transport session, so events sent while consumer was down
+// are delivered after it is back. consumerName together with node name and filter identifies the session,
+// so it should be stable between restarts.
+// Requires transport implementing DurableSubscriber
+func (n *Node) GetDurableSubscription(filter string, consumerName string, opts ...SubscriptionOptions) (*Subscription, error) {
+	tr, ok := n.tr.(DurableSubscriber)
+	if !ok {
+		return nil, fmt.Errorf("transport %T does not support durable subscriptions", n.tr)
+	}
+	session := generatePersistentQueueName(filter, n.Name, consumerName)
+	return n.subscribe(filter, func(topic string, data chan *Message) error {
+		return tr.SubscribeDurable(topic, session, data)
+	}, opts...)
+}
+
+// GetGroupSubscription subscribes as member of group; events are load-balanced between all members
+// (typically replicas of the same service) instead of each getting a copy.
+// Requires transport implementing SharedSubscriber
+func (n *Node) GetGroupSubscription(filter string, group string, opts ...SubscriptionOptions) (*Subscription, error) {
+	tr, ok := n.tr.(SharedSubscriber)
+	if !ok {
+		return nil, fmt.Errorf("transport %T: %w", n.tr, ErrSharedSubscriptionUnsupported{})
+	}
+	return n.subscribe(filter, func(topic string, data chan *Message) error {
+		return tr.SubscribeShared(topic, group, data)
+	}, opts...)
+}
+
+func (n *Node) subscribe(filter string, subscribeFn func(topic string, data chan *Message) error, opts ...SubscriptionOptions) (*Subscription, error) {
+	o := n.subscriptionDefaults
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = DefaultEventBufferSize
+	}
+	if o.MaxBacklog <= 0 {
+		o.MaxBacklog = DefaultMaxBacklog
+	}
+	s := &Subscription{
+		Events: make(chan Event, o.BufferSize),
+		Filter: filter,
+		opts:   o,
+		n:      n,
+	}
+	if o.Overflow == OverflowBlock {
+		s.backlogReady = make(chan struct{}, 1)
+		go s.drainBacklog()
+	}
+	// replay guard tracks seen events per subscription
+	scope := strconv.FormatUint(n.subscriptionSeq.Add(1), 10)
+	messages := make(chan *Message, o.BufferSize)
+	err := subscribeFn(n.eventRoot+"/"+filter, messages)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for m := range messages {
+			// retained message removal, nothing to decode
+			if len(m.Payload) == 0 {
+				continue
+			}
+			ev := &Event{}
+			ev, err := ev.Deserialize(m.Payload, n)
+			if err != nil {
+				n.l.Errorf("error unmarshalling payload [%s]: %s", m.Topic, err)
+				n.deadLetter(m, err)
+				continue
+			}
+			ev.Redelivered = m.Duplicate
+			ev.Retained = m.Retain
+			ev.Topic = m.Topic
+			if n.dedupe != nil && n.dedupe.Seen(filter, ev) {
+				n.l.Debugf("dropping duplicate event from %s[%s] on [%s]", ev.NodeName, ev.NodeUUID, m.Topic)
+				continue
+			}
+			if n.replayGuard != nil {
+				if err := n.replayGuard.Check(scope, ev); err != nil {
+					n.l.Debugf("dropping event from %s[%s] on [%s]: %s", ev.NodeName, ev.NodeUUID, m.Topic, err)
+					continue
+				}
+			}
+			if err := n.receiveChain(s.deliver)(m.Topic, ev); err != nil {
+				n.reportError(fmt.Errorf("dropping event on [%s]: %w", m.Topic, err))
+				n.deadLetter(m, err)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// deliver puts event into channel according to overflow policy
+func (s *Subscription) deliver(topic string, ev *Event) error {
+	switch s.opts.Overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.Events <- *ev:
+				return nil
+			default:
+			}
+			select {
+			case <-s.Events:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	case OverflowDropNewest, OverflowError:
+		select {
+		case s.Events <- *ev:
+		default:
+			s.dropped.Add(1)
+			if s.opts.Overflow == OverflowError {
+				err := ErrSubscriptionOverflow{Filter: s.Filter, Topic: topic}
+				if s.opts.OnOverflow != nil {
+					s.opts.OnOverflow(err)
+				} else {
+					s.n.reportError(err)
+				}
+			}
+		}
+	default:
+		// always thru backlog, so events can't overtake ones already waiting there
+		s.backlogLock.Lock()
+		if len(s.backlog) >= s.opts.MaxBacklog {
+			s.backlog[0] = Event{}
+			s.backlog = s.backlog[1:]
+			s.dropped.Add(1)
+		}
+		s.backlog = append(s.backlog, *ev)
+		s.backlogLock.Unlock()
+		select {
+		case s.backlogReady <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// drainBacklog moves events from backlog to Events, waiting for consumer
+func (s *Subscription) drainBacklog() {
+	for range s.backlogReady {
+		for {
+			s.backlogLock.Lock()
+			if len(s.backlog) == 0 {
+				// release memory of large backlog once consumer caught up
+				s.backlog = nil
+				s.backlogLock.Unlock()
+				break
+			}
+			ev := s.backlog[0]
+			s.backlog[0] = Event{}
+			s.backlog = s.backlog[1:]
+			s.backlogLock.Unlock()
+			s.Events <- ev
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package zerosvc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionOverflow(t *testing.T) {
+	var hookErrors []error
+	node := getTestDummyNode(t, Config{
+		SubscriptionDefaults: SubscriptionOptions{BufferSize: 2, Overflow: OverflowDropNewest},
+		ErrorHook:            func(err error) { hookErrors = append(hookErrors, err) },
+	})
+	events := func() []*Event {
+		out := []*Event{}
+		for i := 0; i < 4; i++ {
+			ev := node.NewEvent()
+			ev.Body = []byte{byte(i)}
+			out = append(out, &ev)
+		}
+		return out
+	}()
+	drain := func(s *Subscription) (bodies []byte) {
+		for {
+			select {
+			case ev := <-s.Events:
+				bodies = append(bodies, ev.Body...)
+			default:
+				return bodies
+			}
+		}
+	}
+	t.Run("defaults from config", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#")
+		require.NoError(t, err)
+		assert.Equal(t, 2, cap(s.Events))
+		assert.Equal(t, OverflowDropNewest, s.opts.Overflow)
+	})
+	t.Run("drop newest", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 2, Overflow: OverflowDropNewest})
+		require.NoError(t, err)
+		for _, ev := range events {
+			require.NoError(t, s.deliver("cakes", ev))
+		}
+		assert.Equal(t, uint64(2), s.Dropped())
+		assert.Equal(t, []byte{0, 1}, drain(s))
+	})
+	t.Run("drop oldest", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 2, Overflow: OverflowDropOldest})
+		require.NoError(t, err)
+		for _, ev := range events {
+			require.NoError(t, s.deliver("cakes", ev))
+		}
+		assert.Equal(t, uint64(2), s.Dropped())
+		assert.Equal(t, []byte{2, 3}, drain(s))
+	})
+	t.Run("error", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 2, Overflow: OverflowError})
+		require.NoError(t, err)
+		for _, ev := range events {
+			require.NoError(t, s.deliver("cakes", ev))
+		}
+		assert.Equal(t, uint64(2), s.Dropped())
+		require.Len(t, hookErrors, 2)
+		assert.ErrorAs(t, hookErrors[0], &ErrSubscriptionOverflow{})
+
+		var cbErrors []error
+		s, err = node.GetSubscription("cakes/#", SubscriptionOptions{
+			BufferSize: 1,
+			Overflow:   OverflowError,
+			OnOverflow: func(err error) { cbErrors = append(cbErrors, err) },
+		})
+		require.NoError(t, err)
+		for _, ev := range events {
+			require.NoError(t, s.deliver("cakes", ev))
+		}
+		assert.Len(t, cbErrors, 3)
+		assert.Len(t, hookErrors, 2)
+	})
+	t.Run("block", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 1, Overflow: OverflowBlock})
+		require.NoError(t, err)
+		for _, ev := range events {
+			// must not wait for consumer
+			require.NoError(t, s.deliver("cakes", ev))
+		}
+		// one in Events, one waiting to be sent there
+		require.Eventually(t, func() bool { return s.Backlog() == 2 }, time.Second, time.Millisecond)
+		var bodies []byte
+		for i := 0; i < len(events); i++ {
+			bodies = append(bodies, (<-s.Events).Body...)
+		}
+		assert.Equal(t, []byte{0, 1, 2, 3}, bodies, "order should be kept")
+		assert.Zero(t, s.Dropped())
+		assert.Zero(t, s.Backlog())
+	})
+	t.Run("block over max backlog", func(t *testing.T) {
+		s, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 1, Overflow: OverflowBlock, MaxBacklog: 1})
+		require.NoError(t, err)
+		count := 16
+		for i := 0; i < count; i++ {
+			require.NoError(t, s.deliver("cakes", &Event{Body: []byte{byte(i)}}))
+		}
+		assert.LessOrEqual(t, s.Backlog(), 1)
+		var bodies []byte
+	recv:
+		for {
+			select {
+			case ev := <-s.Events:
+				bodies = append(bodies, ev.Body...)
+			case <-time.After(time.Millisecond * 50):
+				break recv
+			}
+		}
+		// at most one in Events, one held by drain goroutine and one in backlog
+		assert.GreaterOrEqual(t, s.Dropped(), uint64(count-3))
+		assert.Equal(t, uint64(count), uint64(len(bodies))+s.Dropped())
+		assert.Equal(t, byte(count-1), bodies[len(bodies)-1], "newest event should be kept")
+	})
+}
+
+func TestDurableSubscriptionUnsupported(t *testing.T) {
+	node := getTestDummyNode(t)
+	_, err := node.GetDurableEventsCh("cakes/#", "eater")
+	assert.ErrorContains(t, err, "durable")
+}
+
+func TestSubscriptionSlowConsumer(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	node, err := NewNode(Config{NodeName: "cakes", Transport: tr, EventRoot: "test"})
+	require.NoError(t, err)
+	slow, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 1, Overflow: OverflowBlock})
+	require.NoError(t, err)
+	fast, err := node.GetSubscription("cakes/#", SubscriptionOptions{BufferSize: 1})
+	require.NoError(t, err)
+	count := DefaultEventBufferSize * 4
+	sent := make(chan error)
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := node.SendEvent("cakes/cake", node.NewEvent()); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+	for i := 0; i < count; i++ {
+		select {
+		case <-fast.Events:
+		case <-time.After(time.Second):
+			t.Fatalf("slow subscriber stalled others after %d events", i)
+		}
+	}
+	require.NoError(t, <-sent)
+	for i := 0; i < count; i++ {
+		<-slow.Events
+	}
+	assert.Zero(t, slow.Dropped())
+}
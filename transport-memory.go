@@ -0,0 +1,215 @@
+package zerosvc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MemoryBroker is in-process message broker for TransportMemory.
+// It supports wildcards, retained messages, wills and shared subscriptions, but not QoS or sessions.
+type MemoryBroker struct {
+	sync.Mutex
+	subs     []*memorySubscription
+	retained map[string]Message
+	// round-robin position of each shared subscription group
+	shared map[string]int
+}
+
+type memorySubscription struct {
+	filter string
+	// group is empty for normal subscriptions
+	group string
+	data  chan *Message
+	tr    *TransportMemory
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		retained: map[string]Message{},
+		shared:   map[string]int{},
+	}
+}
+
+func (b *MemoryBroker) publish(m Message) {
+	b.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	targets := []chan *Message{}
+	groups := map[string][]*memorySubscription{}
+	groupOrder := []string{}
+	for _, s := range b.subs {
+		if !topicMatch(s.filter, m.Topic) {
+			continue
+		}
+		if s.group == "" {
+			targets = append(targets, s.data)
+			continue
+		}
+		key := s.group + "\000" + s.filter
+		if _, ok := groups[key]; !ok {
+			groupOrder = append(groupOrder, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+	for _, key := range groupOrder {
+		members := groups[key]
+		idx := b.shared[key] % len(members)
+		b.shared[key] = idx + 1
+		targets = append(targets, members[idx].data)
+	}
+	b.Unlock()
+	for _, ch := range targets {
+		msg := m
+		// like on real broker, retain flag is only set on messages delivered on subscribe
+		msg.Retain = false
+		ch <- &msg
+	}
+}
+
+func (b *MemoryBroker) subscribe(s *memorySubscription) {
+	b.Lock()
+	b.subs = append(b.subs, s)
+	retained := []Message{}
+	if s.group == "" {
+		for topic, m := range b.retained {
+			if topicMatch(s.filter, topic) {
+				retained = append(retained, m)
+			}
+		}
+	}
+	b.Unlock()
+	for _, m := range retained {
+		msg := m
+		s.data <- &msg
+	}
+}
+
+func (b *MemoryBroker) unsubscribeAll(tr *TransportMemory) {
+	b.Lock()
+	defer b.Unlock()
+	subs := b.subs[:0]
+	for _, s := range b.subs {
+		if s.tr != tr {
+			subs = append(subs, s)
+		}
+	}
+	b.subs = subs
+}
+
+// Retained returns copy of retained message on topic
+func (b *MemoryBroker) Retained(topic string) (m Message, found bool) {
+	b.Lock()
+	defer b.Unlock()
+	m, found = b.retained[topic]
+	return m, found
+}
+
+// TransportMemory is in-process transport, mostly useful for tests. Transports sharing the same
+// MemoryBroker can talk with each other
+type TransportMemory struct {
+	broker    *MemoryBroker
+	willPath  string
+	hooks     Hooks
+	connected bool
+	sync.Mutex
+}
+
+type ConfigMemory struct {
+	// Broker shared between transports. New one will be created if not set
+	Broker *MemoryBroker
+}
+
+func NewTransportMemory(cfg ConfigMemory) (*TransportMemory, error) {
+	t := &TransportMemory{
+		broker: cfg.Broker,
+	}
+	if t.broker == nil {
+		t.broker = NewMemoryBroker()
+	}
+	return t, nil
+}
+
+// Broker returns broker used by the transport
+func (t *TransportMemory) Broker() *MemoryBroker {
+	return t.broker
+}
+
+func (t *TransportMemory) Connect(h Hooks, willPath string) error {
+	if len(willPath) == 0 {
+		return fmt.Errorf("will required")
+	}
+	t.Lock()
+	t.willPath = willPath
+	t.hooks = h
+	t.connected = true
+	t.Unlock()
+	if h.ConnectHook != nil {
+		h.ConnectHook()
+	}
+	return nil
+}
+
+func (t *TransportMemory) Publish(m Message) error {
+	t.Lock()
+	connected := t.connected
+	t.Unlock()
+	if !connected {
+		return fmt.Errorf("not connected")
+	}
+	t.broker.publish(m)
+	return nil
+}
+
+func (t *TransportMemory) Subscribe(topic string, data chan *Message) error {
+	t.broker.subscribe(&memorySubscription{filter: topic, data: data, tr: t})
+	return nil
+}
+
+// SubscribeShared emulates MQTTv5 shared subscription; each message is delivered to only one subscriber in the group
+func (t *TransportMemory) SubscribeShared(topic string, group string, data chan *Message) error {
+	if len(group) == 0 || strings.ContainsAny(group, "/+#") {
+		return fmt.Errorf("invalid group name [%s]", group)
+	}
+	t.broker.subscribe(&memorySubscription{filter: topic, group: group, data: data, tr: t})
+	return nil
+}
+
+// CarriesMetadata returns true, messages are delivered as published
+func (t *TransportMemory) CarriesMetadata() bool {
+	return true
+}
+
+func (t *TransportMemory) HeartbeatMessage(m Message) error {
+	m.Retain = true
+	m.Topic = t.willPath
+	return t.Publish(m)
+}
+
+// Disconnect disconnects cleanly, without sending will
+func (t *TransportMemory) Disconnect() error {
+	t.Lock()
+	t.connected = false
+	t.Unlock()
+	t.broker.unsubscribeAll(t)
+	return nil
+}
+
+// Kill simulates connection loss: subscriptions are dropped, will is published and ConnectionLossHook called
+func (t *TransportMemory) Kill() {
+	t.Lock()
+	t.connected = false
+	h := t.hooks
+	willPath := t.willPath
+	t.Unlock()
+	t.broker.unsubscribeAll(t)
+	t.broker.publish(Message{Topic: willPath, Payload: []byte{}, Retain: true})
+	if h.ConnectionLossHook != nil {
+		h.ConnectionLossHook(fmt.Errorf("connection killed"))
+	}
+}
@@ -0,0 +1,112 @@
+package zerosvc
+
+import (
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTopicMatch(t *testing.T) {
+	assert.True(t, topicMatch("a/b", "a/b"))
+	assert.True(t, topicMatch("a/+/c", "a/b/c"))
+	assert.True(t, topicMatch("a/#", "a/b/c"))
+	assert.True(t, topicMatch("a/#", "a"))
+	assert.True(t, topicMatch("#", "a/b"))
+	assert.False(t, topicMatch("a/+", "a/b/c"))
+	assert.False(t, topicMatch("a/b/c", "a/b"))
+	assert.False(t, topicMatch("a/b", "a/c"))
+}
+
+func TestTransportMemory(t *testing.T) {
+	broker := NewMemoryBroker()
+	tr1, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	tr2, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	lost := false
+	require.NoError(t, tr1.Connect(Hooks{ConnectionLossHook: func(err error) { lost = true }}, "discovery/tr1"))
+	require.NoError(t, tr2.Connect(Hooks{}, "discovery/tr2"))
+
+	require.NoError(t, tr1.HeartbeatMessage(Message{Payload: []byte("alive")}))
+	subCh := make(chan *Message, 8)
+	require.NoError(t, tr2.Subscribe("discovery/#", subCh))
+	ret := goneric.ChanToSliceNTimeout(subCh, 1, time.Second)
+	require.Len(t, ret, 1)
+	assert.Equal(t, "discovery/tr1", ret[0].Topic)
+	assert.True(t, ret[0].Retain)
+
+	require.NoError(t, tr2.Publish(Message{Topic: "discovery/other", Payload: []byte("cake")}))
+	ret = goneric.ChanToSliceNTimeout(subCh, 1, time.Second)
+	require.Len(t, ret, 1)
+	assert.Equal(t, []byte("cake"), ret[0].Payload)
+	assert.False(t, ret[0].Retain)
+
+	tr1.Kill()
+	assert.True(t, lost)
+	ret = goneric.ChanToSliceNTimeout(subCh, 1, time.Second)
+	require.Len(t, ret, 1)
+	assert.Equal(t, "discovery/tr1", ret[0].Topic)
+	assert.Empty(t, ret[0].Payload)
+	_, found := broker.Retained("discovery/tr1")
+	assert.False(t, found)
+	assert.Error(t, tr1.Publish(Message{Topic: "test"}))
+
+	require.NoError(t, tr2.Disconnect())
+	require.NoError(t, tr2.Connect(Hooks{}, "discovery/tr2"))
+	require.NoError(t, tr2.Publish(Message{Topic: "discovery/other", Payload: []byte("cake")}))
+	assert.Len(t, subCh, 0)
+}
+
+func TestTransportMemoryShared(t *testing.T) {
+	broker := NewMemoryBroker()
+	pub, _ := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, pub.Connect(Hooks{}, "discovery/pub"))
+	workers := []chan *Message{}
+	for i := 0; i < 3; i++ {
+		tr, _ := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, tr.Connect(Hooks{}, "discovery/worker"))
+		ch := make(chan *Message, 16)
+		require.NoError(t, tr.SubscribeShared("jobs/#", "workers", ch))
+		workers = append(workers, ch)
+	}
+	assert.Error(t, pub.SubscribeShared("jobs/#", "bad/group", make(chan *Message)))
+	observer := make(chan *Message, 16)
+	require.NoError(t, pub.Subscribe("jobs/#", observer))
+	for i := 0; i < 9; i++ {
+		require.NoError(t, pub.Publish(Message{Topic: "jobs/cake", Payload: []byte{byte(i)}}))
+	}
+	for _, w := range workers {
+		assert.Len(t, w, 3)
+	}
+	assert.Len(t, observer, 9)
+}
+
+func TestNodeGroupEvents(t *testing.T) {
+	broker := NewMemoryBroker()
+	newNode := func(name string) *Node {
+		tr, err := NewTransportMemory(ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := NewNode(Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n
+	}
+	replica1 := newNode("replica1")
+	replica2 := newNode("replica2")
+	ch1, err := replica1.GetEventsChGroup("jobs/#", "bakers")
+	require.NoError(t, err)
+	ch2, err := replica2.GetEventsChGroup("jobs/#", "bakers")
+	require.NoError(t, err)
+	client := newNode("client")
+	for i := 0; i < 4; i++ {
+		require.NoError(t, client.SendEvent("jobs/bake", client.NewEvent()))
+	}
+	got1 := goneric.ChanToSliceNTimeout(ch1, 2, time.Second)
+	got2 := goneric.ChanToSliceNTimeout(ch2, 2, time.Second)
+	assert.Len(t, got1, 2)
+	assert.Len(t, got2, 2)
+
+	_, err = getTestDummyNode(t).GetEventsChGroup("jobs/#", "bakers")
+	assert.ErrorIs(t, err, ErrSharedSubscriptionUnsupported{})
+}
@@ -21,6 +21,15 @@ func sanitizeClientID(id string) string {
 	}
 }
 
+// isTLSScheme returns true for URL schemes MQTT libraries connect to over TLS
+func isTLSScheme(scheme string) bool {
+	switch scheme {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
 func getTLSConfigFromURL(url *url.URL) (tlsConfig *tls.Config, clientCertSubject string, err error) {
 	var tlsCfg tls.Config
 	if len(url.Query().Get("cert")) > 0 {
@@ -11,13 +11,16 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 type TransportMQTTv3 struct {
-	client     mqtt.Client
-	clientOpts *mqtt.ClientOptions
-	willPath   string
+	client         mqtt.Client
+	clientOpts     *mqtt.ClientOptions
+	willPath       string
+	durableClients []mqtt.Client
+	durableLock    sync.Mutex
 }
 
 type ConfigMQTTv3 struct {
@@ -47,7 +50,7 @@ func NewTransportMQTTv3(cfg ConfigMQTTv3) (*TransportMQTTv3, error) {
 	tr.clientOpts.SetConnectRetryInterval(time.Second * 10)
 	tr.clientOpts.SetMaxReconnectInterval(time.Minute)
 
-	if cfg.MQTTURL[0].Scheme == "ssl" {
+	if isTLSScheme(cfg.MQTTURL[0].Scheme) {
 		var tlsCfg tls.Config
 		if len(cfg.MQTTURL[0].Query().Get("cert")) > 0 {
 			certPath := cfg.MQTTURL[0].Query().Get("cert")
@@ -132,16 +135,78 @@ func (t *TransportMQTTv3) Publish(m Message) error {
 }
 
 func (t *TransportMQTTv3) Subscribe(topic string, data chan *Message) error {
-	cb := func(client mqtt.Client, msg mqtt.Message) {
+	token := t.client.Subscribe(topic, 1, mqttv3Handler(data))
+	token.Wait()
+	return token.Error()
+}
+
+// SubscribeDurable creates separate client with CleanSession=false and client ID derived from session
+// and subscribes it with QoS1. Broker will queue messages for it while it is disconnected
+func (t *TransportMQTTv3) SubscribeDurable(topic string, session string, data chan *Message) error {
+	cb := mqttv3Handler(data)
+	opts := *t.clientOpts
+	opts.SetClientID(sanitizeClientID(session))
+	opts.SetCleanSession(false)
+	// will is only for the main connection
+	opts.WillEnabled = false
+	// messages queued in the session can arrive before subscribe call returns
+	opts.SetDefaultPublishHandler(cb)
+	subscribed := make(chan error, 1)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		token := c.Subscribe(topic, 1, cb)
+		token.Wait()
+		select {
+		case subscribed <- token.Error():
+		default:
+		}
+	})
+	opts.SetConnectionLostHandler(nil)
+	client := mqtt.NewClient(&opts)
+	connectToken := client.Connect()
+	if !connectToken.WaitTimeout(time.Minute) {
+		return fmt.Errorf("timed out on durable connection [%s]", connectToken.Error())
+	}
+	if connectToken.Error() != nil {
+		return connectToken.Error()
+	}
+	t.durableLock.Lock()
+	t.durableClients = append(t.durableClients, client)
+	t.durableLock.Unlock()
+	select {
+	case err := <-subscribed:
+		return err
+	case <-time.After(time.Minute):
+		return fmt.Errorf("timed out waiting for durable subscription to %s", topic)
+	}
+}
+
+// SubscribeShared subscribes to $share/<group>/<topic>. It is not part of MQTTv3 standard
+// but many brokers support it; ErrSharedSubscriptionUnsupported is returned if broker rejects it
+func (t *TransportMQTTv3) SubscribeShared(topic string, group string, data chan *Message) error {
+	shareTopic := "$share/" + group + "/" + topic
+	token := t.client.Subscribe(shareTopic, 1, mqttv3Handler(data))
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
+	}
+	if st, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := st.Result()[shareTopic]; ok && code >= 0x80 {
+			return ErrSharedSubscriptionUnsupported{}
+		}
+	}
+	return nil
+}
+
+func mqttv3Handler(data chan *Message) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
 		m := Message{
-			Topic:   msg.Topic(),
-			Payload: msg.Payload(),
+			Topic:     msg.Topic(),
+			Payload:   msg.Payload(),
+			Retain:    msg.Retained(),
+			Duplicate: msg.Duplicate(),
 		}
 		data <- &m
 	}
-	token := t.client.Subscribe(topic, 1, cb)
-	token.Wait()
-	return token.Error()
 }
 func (t *TransportMQTTv3) SetConnectHandler(topic string, data []byte) {
 }
@@ -150,6 +215,12 @@ func (t *TransportMQTTv3) Disconnect() {
 	if t.client != nil {
 		t.client.Disconnect(10000)
 	}
+	t.durableLock.Lock()
+	for _, c := range t.durableClients {
+		c.Disconnect(10000)
+	}
+	t.durableClients = nil
+	t.durableLock.Unlock()
 	// make sure it is NOT reused.
 	t.client = nil
 }
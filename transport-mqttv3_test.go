@@ -53,3 +53,14 @@ func TestNewTransportMQTTV3(t *testing.T) {
 		tr2.Subscribe(chName, subCh)
 	})
 }
+
+func TestMQTTTLSSchemes(t *testing.T) {
+	for _, scheme := range []string{"ssl", "tls", "mqtts", "wss"} {
+		u, err := url.Parse(scheme + "://127.0.0.1:8883/?ca=/nonexistent/ca.crt")
+		require.NoError(t, err)
+		_, err = NewTransportMQTTv3(ConfigMQTTv3{ID: t.Name(), MQTTURL: []*url.URL{u}})
+		assert.ErrorContains(t, err, "CA", "v3 should load TLS options for %s", scheme)
+		_, err = NewTransportMQTTv5(ConfigMQTTv5{ID: t.Name(), MQTTURL: []*url.URL{u}})
+		assert.ErrorContains(t, err, "CA", "v5 should load TLS options for %s", scheme)
+	}
+}
@@ -3,41 +3,57 @@ package zerosvc
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	mqtt "github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
 	"github.com/eclipse/paho.golang/paho"
 	"go.uber.org/zap"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 type TransportMQTTv5 struct {
-	mqttCtx  context.Context
-	mqttCfg  mqtt.ClientConfig
-	client   *mqtt.ConnectionManager
-	router   paho.Router
-	timeout  time.Duration
-	willPath string
-	l        *zap.SugaredLogger
+	mqttCtx        context.Context
+	mqttCfg        mqtt.ClientConfig
+	client         *mqtt.ConnectionManager
+	router         paho.Router
+	timeout        time.Duration
+	willPath       string
+	sessionExpiry  time.Duration
+	durableClients []*mqtt.ConnectionManager
+	durableLock    sync.Mutex
+	l              *zap.SugaredLogger
 }
 
+// DefaultSessionExpiry is how long broker keeps durable subscription session of disconnected consumer
+const DefaultSessionExpiry = time.Hour * 24
+
 type ConfigMQTTv5 struct {
 	ID      string
 	MQTTURL []*url.URL
 	Logger  *zap.SugaredLogger
+	// SessionExpiry of durable subscriptions, DefaultSessionExpiry if 0
+	SessionExpiry time.Duration
 }
 
 func NewTransportMQTTv5(cfg ConfigMQTTv5) (*TransportMQTTv5, error) {
 	var tlsC *tls.Config
 	mqttTr := &TransportMQTTv5{
-		mqttCtx: context.Background(),
-		timeout: time.Second * 30, // # TODO config
-		l:       cfg.Logger,
+		mqttCtx:       context.Background(),
+		timeout:       time.Second * 30, // # TODO config
+		sessionExpiry: cfg.SessionExpiry,
+		l:             cfg.Logger,
+	}
+	if mqttTr.sessionExpiry <= 0 {
+		mqttTr.sessionExpiry = DefaultSessionExpiry
 	}
 	if mqttTr.l == nil {
 		mqttTr.l = zap.NewNop().Sugar()
 	}
-	if cfg.MQTTURL[0].Scheme == "ssl" {
+	if isTLSScheme(cfg.MQTTURL[0].Scheme) {
 		var subject string
 		var err error
 		tlsC, subject, err = getTLSConfigFromURL(cfg.MQTTURL[0])
@@ -108,7 +124,8 @@ func (t *TransportMQTTv5) Connect(h Hooks, willPath string) error {
 	if err != nil {
 		return err
 	}
-	connectCtx, _ := context.WithTimeout(t.mqttCtx, time.Second*30)
+	connectCtx, cancel := context.WithTimeout(t.mqttCtx, time.Second*30)
+	defer cancel()
 	if err = conn.AwaitConnection(connectCtx); err != nil {
 		return fmt.Errorf("error connecting to mq: %w", err)
 	}
@@ -116,11 +133,14 @@ func (t *TransportMQTTv5) Connect(h Hooks, willPath string) error {
 	t.client = conn
 	return nil
 }
+
+// Publish sends message with QoS1, so broker queues it for offline durable sessions
 func (t *TransportMQTTv5) Publish(m Message) error {
-	pubTimeout, _ := context.WithTimeout(t.mqttCtx, t.timeout)
+	pubTimeout, cancel := context.WithTimeout(t.mqttCtx, t.timeout)
+	defer cancel()
 	ev := &paho.Publish{
 		PacketID: 0,
-		QoS:      0,
+		QoS:      1,
 		Retain:   m.Retain,
 		Topic:    m.Topic,
 		Properties: &paho.PublishProperties{
@@ -135,6 +155,9 @@ func (t *TransportMQTTv5) Publish(m Message) error {
 		},
 		Payload: m.Payload,
 	}
+	for k, v := range m.Metadata {
+		ev.Properties.User.Add(k, v)
+	}
 	resp, err := t.client.Publish(pubTimeout, ev)
 	if err != nil {
 		//return fmt.Errorf("pub %w: %s[%d]", err, resp.Properties.ReasonString, resp.ReasonCode)
@@ -145,7 +168,8 @@ func (t *TransportMQTTv5) Publish(m Message) error {
 }
 
 func (t *TransportMQTTv5) Subscribe(topic string, data chan *Message) error {
-	subTimeout, _ := context.WithTimeout(t.mqttCtx, t.timeout)
+	subTimeout, cancel := context.WithTimeout(t.mqttCtx, t.timeout)
+	defer cancel()
 	sub := &paho.Subscribe{
 		Properties: nil,
 		Subscriptions: []paho.SubscribeOptions{
@@ -162,7 +186,84 @@ func (t *TransportMQTTv5) Subscribe(topic string, data chan *Message) error {
 	if err != nil {
 		return fmt.Errorf("sub %w: %s[%+v]", err, suback.Properties.ReasonString, suback.Reasons)
 	}
-	t.router.RegisterHandler(topic, func(p *paho.Publish) {
+	t.router.RegisterHandler(topic, mqttv5Handler(data))
+	return nil
+}
+
+// SubscribeDurable creates separate connection with client ID derived from session and session expiry set,
+// and subscribes it with QoS1. Broker will queue messages for it while it is disconnected
+func (t *TransportMQTTv5) SubscribeDurable(topic string, session string, data chan *Message) error {
+	router := paho.NewStandardRouter()
+	router.RegisterHandler(topic, mqttv5Handler(data))
+	cfg := t.mqttCfg
+	cfg.ClientConfig.ClientID = sanitizeClientID(session)
+	cfg.ClientConfig.Router = router
+	cfg.CleanStartOnInitialConnection = false
+	cfg.SessionExpiryInterval = uint32(t.sessionExpiry.Seconds())
+	// will is only for the main connection
+	cfg.WillMessage = nil
+	cfg.WillProperties = nil
+	subscribed := make(chan error, 1)
+	cfg.OnConnectionUp = func(cm *mqtt.ConnectionManager, ca *paho.Connack) {
+		subTimeout, cancel := context.WithTimeout(t.mqttCtx, t.timeout)
+		defer cancel()
+		_, err := cm.Subscribe(subTimeout, &paho.Subscribe{
+			Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: 1}},
+		})
+		if err != nil {
+			t.l.Errorf("error subscribing durable session %s to %s: %s", session, topic, err)
+		}
+		select {
+		case subscribed <- err:
+		default:
+		}
+	}
+	conn, err := mqtt.NewConnection(t.mqttCtx, cfg)
+	if err != nil {
+		return err
+	}
+	t.durableLock.Lock()
+	t.durableClients = append(t.durableClients, conn)
+	t.durableLock.Unlock()
+	select {
+	case err = <-subscribed:
+		return err
+	case <-time.After(t.timeout):
+		return fmt.Errorf("timed out waiting for durable subscription to %s", topic)
+	}
+}
+
+// SubscribeShared subscribes to $share/<group>/<topic>.
+//
+// Note that router can't tell which subscription message came from, so having both shared
+// and normal subscription on overlapping topics will deliver messages from both to both
+func (t *TransportMQTTv5) SubscribeShared(topic string, group string, data chan *Message) error {
+	shareTopic := "$share/" + group + "/" + topic
+	subTimeout, cancel := context.WithTimeout(t.mqttCtx, t.timeout)
+	defer cancel()
+	suback, err := t.client.Subscribe(subTimeout, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: shareTopic, QoS: 1}},
+	})
+	if err != nil {
+		if errors.Is(err, paho.ErrInvalidArguments) && strings.Contains(err.Error(), "shared") {
+			return ErrSharedSubscriptionUnsupported{}
+		}
+		return fmt.Errorf("sub %w", err)
+	}
+	if len(suback.Reasons) > 0 {
+		if suback.Reasons[0] == packets.SubackSharedSubscriptionnotsupported {
+			return ErrSharedSubscriptionUnsupported{}
+		}
+		if suback.Reasons[0] >= 0x80 {
+			return fmt.Errorf("sub to %s failed with reason 0x%x", shareTopic, suback.Reasons[0])
+		}
+	}
+	t.router.RegisterHandler(shareTopic, mqttv5Handler(data))
+	return nil
+}
+
+func mqttv5Handler(data chan *Message) func(p *paho.Publish) {
+	return func(p *paho.Publish) {
 		msg := Message{
 			Topic:           p.Topic,
 			ResponseTopic:   p.Properties.ResponseTopic,
@@ -170,6 +271,8 @@ func (t *TransportMQTTv5) Subscribe(topic string, data chan *Message) error {
 			ContentType:     p.Properties.ContentType,
 			Metadata:        map[string]string{},
 			Payload:         p.Payload,
+			Retain:          p.Retain,
+			Duplicate:       p.Duplicate(),
 		}
 		if len(p.Properties.User) > 0 {
 			for _, prop := range p.Properties.User {
@@ -177,8 +280,12 @@ func (t *TransportMQTTv5) Subscribe(topic string, data chan *Message) error {
 			}
 		}
 		data <- &msg
-	})
-	return nil
+	}
+}
+
+// CarriesMetadata returns true, metadata is sent as user properties
+func (t *TransportMQTTv5) CarriesMetadata() bool {
+	return true
 }
 
 func (t *TransportMQTTv5) HeartbeatMessage(m Message) error {
@@ -188,5 +295,11 @@ func (t *TransportMQTTv5) HeartbeatMessage(m Message) error {
 }
 
 func (t *TransportMQTTv5) Disconnect() error {
+	t.durableLock.Lock()
+	for _, c := range t.durableClients {
+		c.Disconnect(t.mqttCtx)
+	}
+	t.durableClients = nil
+	t.durableLock.Unlock()
 	return t.client.Disconnect(t.mqttCtx)
 }
@@ -0,0 +1,524 @@
+package zerosvc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"go.uber.org/zap"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultOutboxMaxSize       = 64 * 1024 * 1024
+	DefaultOutboxRetryInterval = time.Second * 10
+	outboxSegmentsPerLog       = 8
+	outboxMinSegmentSize       = 64 * 1024
+	outboxSegmentSuffix        = ".log"
+	outboxOffsetFile           = "offset"
+	// how often (in records) replay progress is saved to disk
+	outboxOffsetSyncEvery = 100
+)
+
+// TransportOutbox wraps another transport and stores published messages in on-disk log
+// when the broker is not reachable, then replays them in order after reconnect.
+//
+// Delivery is at-least-once: crash during replay can cause up to outboxOffsetSyncEvery messages to be sent again.
+type TransportOutbox struct {
+	tr            Transport
+	dir           string
+	maxSize       int64
+	segmentSize   int64
+	maxAge        time.Duration
+	retryInterval time.Duration
+	l             *zap.SugaredLogger
+
+	sync.Mutex
+	connected bool
+	replaying bool
+	segments  []uint64
+	sizes     map[uint64]int64
+	// position of next record to replay
+	headOffset int64
+	tail       *os.File
+	depth      int
+	dropped    uint64
+	sinceSync  int
+}
+
+type ConfigOutbox struct {
+	// Transport used to actually send messages
+	Transport Transport
+	// Dir to store the log in. Will be created if it does not exist
+	Dir string
+	// MaxSize of the log in bytes, DefaultOutboxMaxSize if 0. Oldest messages are dropped when it is exceeded
+	MaxSize int64
+	// MaxAge of queued messages; older ones are discarded instead of replayed. 0 means no limit
+	MaxAge time.Duration
+	// RetryInterval of replay after failed publish, DefaultOutboxRetryInterval if 0
+	RetryInterval time.Duration
+	Logger        *zap.SugaredLogger
+}
+
+type outboxRecord struct {
+	TS      time.Time `cbor:"ts"`
+	Message Message   `cbor:"m"`
+}
+
+func NewTransportOutbox(cfg ConfigOutbox) (*TransportOutbox, error) {
+	if cfg.Transport == nil {
+		return nil, fmt.Errorf("transport required in config")
+	}
+	if len(cfg.Dir) == 0 {
+		return nil, fmt.Errorf("outbox dir required")
+	}
+	t := &TransportOutbox{
+		tr:            cfg.Transport,
+		dir:           cfg.Dir,
+		maxSize:       cfg.MaxSize,
+		maxAge:        cfg.MaxAge,
+		retryInterval: cfg.RetryInterval,
+		l:             cfg.Logger,
+		sizes:         map[uint64]int64{},
+	}
+	if t.l == nil {
+		t.l = zap.NewNop().Sugar()
+	}
+	if t.maxSize <= 0 {
+		t.maxSize = DefaultOutboxMaxSize
+	}
+	if t.retryInterval <= 0 {
+		t.retryInterval = DefaultOutboxRetryInterval
+	}
+	t.segmentSize = t.maxSize / outboxSegmentsPerLog
+	if t.segmentSize < outboxMinSegmentSize {
+		t.segmentSize = outboxMinSegmentSize
+	}
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating outbox dir: %w", err)
+	}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// open loads existing segments, repairs torn tail write and counts queued messages
+func (t *TransportOutbox) open() error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), outboxSegmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), outboxSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		t.segments = append(t.segments, id)
+	}
+	sort.Slice(t.segments, func(i, j int) bool { return t.segments[i] < t.segments[j] })
+	if len(t.segments) == 0 {
+		t.segments = []uint64{1}
+	}
+	if off, err := os.ReadFile(filepath.Join(t.dir, outboxOffsetFile)); err == nil {
+		var seg uint64
+		var pos int64
+		if _, err := fmt.Sscanf(string(off), "%d %d", &seg, &pos); err == nil && seg == t.segments[0] {
+			t.headOffset = pos
+		}
+	}
+	for i, id := range t.segments {
+		valid, count, err := scanSegment(t.segmentPath(id))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading outbox segment %d: %w", id, err)
+		}
+		if i == len(t.segments)-1 {
+			// cut partially written record
+			if err := os.Truncate(t.segmentPath(id), valid); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		t.sizes[id] = valid
+		t.depth += count
+	}
+	if t.headOffset > 0 {
+		skipped, err := countRecords(t.segmentPath(t.segments[0]), t.headOffset)
+		if err != nil {
+			return err
+		}
+		t.depth -= skipped
+	}
+	tailID := t.segments[len(t.segments)-1]
+	t.tail, err = os.OpenFile(t.segmentPath(tailID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	return err
+}
+
+func (t *TransportOutbox) segmentPath(id uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%020d%s", id, outboxSegmentSuffix))
+}
+
+// Connect connects underlying transport, intercepting hooks to track connection state
+func (t *TransportOutbox) Connect(h Hooks, willTopic string) error {
+	hooks := Hooks{
+		ConnectHook: func() {
+			t.Lock()
+			t.connected = true
+			t.Unlock()
+			go t.replay()
+			if h.ConnectHook != nil {
+				h.ConnectHook()
+			}
+		},
+		ConnectionLossHook: func(err error) {
+			t.Lock()
+			t.connected = false
+			t.Unlock()
+			if h.ConnectionLossHook != nil {
+				h.ConnectionLossHook(err)
+			}
+		},
+	}
+	return t.tr.Connect(hooks, willTopic)
+}
+
+// Publish sends message directly if connected and nothing is queued, else appends it to the log.
+// Direct sends are done without holding the lock, so publisher stuck on dead connection does not block others
+func (t *TransportOutbox) Publish(m Message) error {
+	t.Lock()
+	if !t.connected || t.depth > 0 || t.replaying {
+		defer t.Unlock()
+		return t.append(m)
+	}
+	t.Unlock()
+	err := t.tr.Publish(m)
+	if err == nil {
+		return nil
+	}
+	t.l.Warnf("publish failed, queueing: %s", err)
+	t.Lock()
+	defer t.Unlock()
+	if err := t.append(m); err != nil {
+		return err
+	}
+	go t.retryLater()
+	return nil
+}
+
+func (t *TransportOutbox) Subscribe(topic string, data chan *Message) error {
+	return t.tr.Subscribe(topic, data)
+}
+
+// SubscribeDurable passes durable subscription to underlying transport, if it supports it
+func (t *TransportOutbox) SubscribeDurable(topic string, session string, data chan *Message) error {
+	tr, ok := t.tr.(DurableSubscriber)
+	if !ok {
+		return fmt.Errorf("transport %T does not support durable subscriptions", t.tr)
+	}
+	return tr.SubscribeDurable(topic, session, data)
+}
+
+// SubscribeShared passes shared subscription to underlying transport, if it supports it
+func (t *TransportOutbox) SubscribeShared(topic string, group string, data chan *Message) error {
+	tr, ok := t.tr.(SharedSubscriber)
+	if !ok {
+		return fmt.Errorf("transport %T: %w", t.tr, ErrSharedSubscriptionUnsupported{})
+	}
+	return tr.SubscribeShared(topic, group, data)
+}
+
+// CarriesMetadata returns whether underlying transport carries metadata
+func (t *TransportOutbox) CarriesMetadata() bool {
+	tr, ok := t.tr.(MetadataCarrier)
+	return ok && tr.CarriesMetadata()
+}
+
+func (t *TransportOutbox) HeartbeatMessage(m Message) error {
+	return t.tr.HeartbeatMessage(m)
+}
+
+// Depth returns number of queued messages
+func (t *TransportOutbox) Depth() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.depth
+}
+
+// Dropped returns number of messages dropped because of size or age limit
+func (t *TransportOutbox) Dropped() uint64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.dropped
+}
+
+// Close closes the log. It does not disconnect underlying transport
+func (t *TransportOutbox) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.saveOffset(); err != nil {
+		return err
+	}
+	return t.tail.Close()
+}
+
+// append writes message to the log; has to be called with lock held
+func (t *TransportOutbox) append(m Message) error {
+	data, err := cbor.Marshal(outboxRecord{TS: time.Now(), Message: m})
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(data))
+	rec = append(rec, data...)
+	tailID := t.segments[len(t.segments)-1]
+	if t.sizes[tailID] > 0 && t.sizes[tailID]+int64(len(rec)) > t.segmentSize {
+		if err := t.tail.Close(); err != nil {
+			return err
+		}
+		tailID++
+		t.tail, err = os.OpenFile(t.segmentPath(tailID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		t.segments = append(t.segments, tailID)
+		t.sizes[tailID] = 0
+	}
+	if _, err := t.tail.Write(rec); err != nil {
+		return fmt.Errorf("error writing to outbox: %w", err)
+	}
+	t.sizes[tailID] += int64(len(rec))
+	t.depth++
+	return t.enforceSize()
+}
+
+// enforceSize drops oldest segments until log fits in max size
+func (t *TransportOutbox) enforceSize() error {
+	for len(t.segments) > 1 {
+		var total int64
+		for _, id := range t.segments {
+			total += t.sizes[id]
+		}
+		if total <= t.maxSize {
+			return nil
+		}
+		headID := t.segments[0]
+		remaining, err := countRecords(t.segmentPath(headID), -1)
+		if err != nil {
+			return err
+		}
+		skipped, err := countRecords(t.segmentPath(headID), t.headOffset)
+		if err != nil {
+			return err
+		}
+		dropped := remaining - skipped
+		t.l.Warnf("outbox over size limit, dropping %d oldest messages", dropped)
+		t.depth -= dropped
+		t.dropped += uint64(dropped)
+		if err := t.removeHead(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TransportOutbox) removeHead() error {
+	headID := t.segments[0]
+	t.segments = t.segments[1:]
+	delete(t.sizes, headID)
+	t.headOffset = 0
+	if err := os.Remove(t.segmentPath(headID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return t.saveOffset()
+}
+
+func (t *TransportOutbox) saveOffset() error {
+	t.sinceSync = 0
+	return os.WriteFile(
+		filepath.Join(t.dir, outboxOffsetFile),
+		[]byte(fmt.Sprintf("%d %d", t.segments[0], t.headOffset)),
+		0o600,
+	)
+}
+
+func (t *TransportOutbox) retryLater() {
+	time.Sleep(t.retryInterval)
+	t.replay()
+}
+
+// replay sends queued messages in order until queue is empty, connection is lost or publish fails
+func (t *TransportOutbox) replay() {
+	t.Lock()
+	if !t.connected || t.replaying {
+		t.Unlock()
+		return
+	}
+	t.replaying = true
+	t.Unlock()
+	for {
+		t.Lock()
+		if !t.connected {
+			t.replaying = false
+			t.saveOffset()
+			t.Unlock()
+			return
+		}
+		rec, size, err := t.readHead()
+		if err == io.EOF {
+			if len(t.segments) > 1 {
+				if err := t.removeHead(); err != nil {
+					t.l.Errorf("error removing outbox segment: %s", err)
+				}
+				t.Unlock()
+				continue
+			}
+			// everything sent, reset the log
+			if err := t.tail.Truncate(0); err != nil {
+				t.l.Errorf("error truncating outbox: %s", err)
+			}
+			t.sizes[t.segments[0]] = 0
+			t.headOffset = 0
+			t.depth = 0
+			t.replaying = false
+			t.saveOffset()
+			t.Unlock()
+			return
+		} else if err != nil {
+			t.l.Errorf("error reading outbox, skipping rest of the segment: %s", err)
+			t.headOffset = t.sizes[t.segments[0]]
+			// skipped records can't be counted, so recount ones left in following segments
+			t.depth = 0
+			for _, id := range t.segments[1:] {
+				_, count, err := scanSegment(t.segmentPath(id))
+				if err != nil && !os.IsNotExist(err) {
+					t.l.Errorf("error reading outbox segment %d: %s", id, err)
+				}
+				t.depth += count
+			}
+			t.Unlock()
+			continue
+		}
+		t.Unlock()
+		if t.maxAge > 0 && time.Since(rec.TS) > t.maxAge {
+			t.Lock()
+			t.dropped++
+		} else {
+			if err := t.tr.Publish(rec.Message); err != nil {
+				t.l.Warnf("error replaying outbox, retrying in %s: %s", t.retryInterval, err)
+				t.Lock()
+				t.replaying = false
+				t.saveOffset()
+				t.Unlock()
+				go t.retryLater()
+				return
+			}
+			t.Lock()
+		}
+		t.headOffset += size
+		t.depth--
+		t.sinceSync++
+		if t.sinceSync >= outboxOffsetSyncEvery {
+			t.saveOffset()
+		}
+		t.Unlock()
+	}
+}
+
+// readHead reads record at head offset; has to be called with lock held
+func (t *TransportOutbox) readHead() (rec outboxRecord, size int64, err error) {
+	// anything past valid size of the segment is corrupted
+	if t.headOffset >= t.sizes[t.segments[0]] {
+		return rec, 0, io.EOF
+	}
+	f, err := os.Open(t.segmentPath(t.segments[0]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rec, 0, io.EOF
+		}
+		return rec, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.headOffset, io.SeekStart); err != nil {
+		return rec, 0, err
+	}
+	data, err := readOutboxRecord(bufio.NewReader(f))
+	if err != nil {
+		return rec, 0, err
+	}
+	err = cbor.Unmarshal(data, &rec)
+	return rec, int64(len(data) + 8), err
+}
+
+func readOutboxRecord(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr[0:4]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return data, nil
+}
+
+// scanSegment returns length of valid data in the segment and number of records in it
+func scanSegment(path string) (valid int64, count int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		data, err := readOutboxRecord(r)
+		if err != nil {
+			// EOF, torn write or corrupted record all end the valid part
+			return valid, count, nil
+		}
+		valid += int64(len(data) + 8)
+		count++
+	}
+}
+
+// countRecords counts records before offset; negative offset counts all
+func countRecords(path string, offset int64) (count int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var pos int64
+	for offset < 0 || pos < offset {
+		data, err := readOutboxRecord(r)
+		if err != nil {
+			return count, nil
+		}
+		pos += int64(len(data) + 8)
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,193 @@
+package zerosvc
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFlakyTransport records published messages and fails while down
+type testFlakyTransport struct {
+	sync.Mutex
+	hooks     Hooks
+	down      bool
+	published []string
+}
+
+func (t *testFlakyTransport) Connect(h Hooks, willTopic string) error {
+	t.hooks = h
+	h.ConnectHook()
+	return nil
+}
+func (t *testFlakyTransport) Publish(m Message) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.down {
+		return fmt.Errorf("broker down")
+	}
+	t.published = append(t.published, string(m.Payload))
+	return nil
+}
+func (t *testFlakyTransport) Subscribe(topic string, data chan *Message) error { return nil }
+func (t *testFlakyTransport) HeartbeatMessage(m Message) error                 { return nil }
+func (t *testFlakyTransport) setDown(down bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.down = down
+}
+func (t *testFlakyTransport) getPublished() []string {
+	t.Lock()
+	defer t.Unlock()
+	return append([]string{}, t.published...)
+}
+
+func TestTransportOutbox(t *testing.T) {
+	dir := t.TempDir()
+	inner := &testFlakyTransport{}
+	ob, err := NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: dir, RetryInterval: time.Millisecond * 10})
+	require.NoError(t, err)
+	require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+
+	require.NoError(t, ob.Publish(Message{Topic: "test", Payload: []byte("0")}))
+	assert.Equal(t, []string{"0"}, inner.getPublished())
+	assert.Equal(t, 0, ob.Depth())
+
+	inner.setDown(true)
+	inner.hooks.ConnectionLossHook(fmt.Errorf("lost"))
+	for i := 1; i < 5; i++ {
+		require.NoError(t, ob.Publish(Message{Topic: "test", Payload: []byte(fmt.Sprint(i))}))
+	}
+	assert.Equal(t, 4, ob.Depth())
+	require.NoError(t, ob.Close())
+
+	// survives restart
+	ob, err = NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: dir, RetryInterval: time.Millisecond * 10})
+	require.NoError(t, err)
+	require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+	assert.Equal(t, 4, ob.Depth())
+	// still down, replay should retry
+	require.NoError(t, ob.Publish(Message{Topic: "test", Payload: []byte("5")}))
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, 5, ob.Depth())
+	inner.setDown(false)
+	assert.Eventually(t, func() bool { return ob.Depth() == 0 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5"}, inner.getPublished())
+
+	// back to direct sending
+	require.NoError(t, ob.Publish(Message{Topic: "test", Payload: []byte("6")}))
+	assert.Equal(t, "6", inner.getPublished()[6])
+	require.NoError(t, ob.Close())
+}
+
+func TestTransportOutboxLimits(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		inner := &testFlakyTransport{down: true}
+		ob, err := NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: t.TempDir(), MaxSize: 2 * outboxMinSegmentSize})
+		require.NoError(t, err)
+		payload := make([]byte, 1024)
+		for i := 0; i < 300; i++ {
+			require.NoError(t, ob.Publish(Message{Topic: "test", Payload: payload}))
+		}
+		assert.Greater(t, ob.Dropped(), uint64(0))
+		assert.Equal(t, 300, ob.Depth()+int(ob.Dropped()))
+		assert.LessOrEqual(t, len(ob.segments), 2)
+		require.NoError(t, ob.Close())
+	})
+	t.Run("age", func(t *testing.T) {
+		inner := &testFlakyTransport{}
+		ob, err := NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: t.TempDir(), MaxAge: time.Millisecond})
+		require.NoError(t, err)
+		require.NoError(t, ob.Publish(Message{Topic: "test", Payload: []byte("old")}))
+		time.Sleep(time.Second)
+		require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+		assert.Eventually(t, func() bool { return ob.Depth() == 0 }, time.Second, time.Millisecond*10)
+		assert.Empty(t, inner.getPublished())
+		assert.Equal(t, uint64(1), ob.Dropped())
+		require.NoError(t, ob.Close())
+	})
+}
+
+func TestTransportOutboxCorruptSegment(t *testing.T) {
+	inner := &testFlakyTransport{down: true}
+	ob, err := NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: t.TempDir(), MaxSize: 4 * outboxMinSegmentSize, RetryInterval: time.Hour})
+	require.NoError(t, err)
+	payload := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, ob.Publish(Message{Topic: "test", Payload: payload}))
+	}
+	require.Len(t, ob.segments, 2)
+	_, left, err := scanSegment(ob.segmentPath(ob.segments[1]))
+	require.NoError(t, err)
+	f, err := os.OpenFile(ob.segmentPath(ob.segments[0]), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff}, 20)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+	// replay skips corrupted head segment, then stops on first failed publish from the next one
+	assert.Eventually(t, func() bool { return ob.Depth() == left }, time.Second, time.Millisecond*10)
+	require.NoError(t, ob.Close())
+}
+
+// testHangingTransport never returns from publish to "hang" topic, like MQTTv3 with dead broker
+type testHangingTransport struct {
+	testFlakyTransport
+	release chan struct{}
+}
+
+func (t *testHangingTransport) Publish(m Message) error {
+	if m.Topic == "hang" {
+		<-t.release
+		return fmt.Errorf("broker down")
+	}
+	return t.testFlakyTransport.Publish(m)
+}
+
+func TestTransportOutboxPublishNotSerialized(t *testing.T) {
+	inner := &testHangingTransport{release: make(chan struct{})}
+	t.Cleanup(func() { close(inner.release) })
+	ob, err := NewTransportOutbox(ConfigOutbox{Transport: inner, Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+	go ob.Publish(Message{Topic: "hang"})
+	time.Sleep(time.Millisecond * 10)
+	done := make(chan error)
+	go func() {
+		done <- ob.Publish(Message{Topic: "test", Payload: []byte("0")})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by another stuck publish")
+	}
+	assert.Equal(t, 0, ob.Depth())
+}
+
+func TestTransportOutboxSubscribeForwarding(t *testing.T) {
+	broker := NewMemoryBroker()
+	mem, err := NewTransportMemory(ConfigMemory{Broker: broker})
+	require.NoError(t, err)
+	ob, err := NewTransportOutbox(ConfigOutbox{Transport: mem, Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, ob.Connect(Hooks{}, "discovery/test"))
+	data := make(chan *Message, 1)
+	require.NoError(t, ob.SubscribeShared("test/#", "workers", data))
+	require.NoError(t, ob.Publish(Message{Topic: "test/job", Payload: []byte("job")}))
+	select {
+	case m := <-data:
+		assert.Equal(t, "job", string(m.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("shared subscription not forwarded")
+	}
+	assert.ErrorContains(t, ob.SubscribeDurable("test/#", "session", data), "durable")
+
+	flaky, err := NewTransportOutbox(ConfigOutbox{Transport: &testFlakyTransport{}, Dir: t.TempDir()})
+	require.NoError(t, err)
+	assert.ErrorIs(t, flaky.SubscribeShared("test/#", "workers", data), ErrSharedSubscriptionUnsupported{})
+}
@@ -8,6 +8,28 @@ type Message struct {
 	Metadata        map[string]string
 	Payload         []byte
 	Retain          bool
+	// Duplicate is transport's DUP/redelivered flag; message might have been delivered before
+	Duplicate bool
+}
+
+// DurableSubscriber is implemented by transports that can keep subscription
+// (and messages queued for it) while consumer is offline
+type DurableSubscriber interface {
+	// SubscribeDurable subscribes using separate, persistent session identified by session.
+	// Messages published with QoS1 while consumer was down are delivered after it reconnects
+	SubscribeDurable(topic string, session string, data chan *Message) error
+}
+
+// SharedSubscriber is implemented by transports that can load-balance messages between group of subscribers
+type SharedSubscriber interface {
+	// SubscribeShared subscribes as a member of the group; each message is delivered to only one member.
+	// Returns ErrSharedSubscriptionUnsupported if broker does not support it
+	SubscribeShared(topic string, group string, data chan *Message) error
+}
+
+// MetadataCarrier is implemented by transports that deliver Message.Metadata to subscribers
+type MetadataCarrier interface {
+	CarriesMetadata() bool
 }
 
 type Hooks struct {
@@ -0,0 +1,108 @@
+package zerosvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Meta is sender-side metadata of the event, without the body
+type Meta struct {
+	NodeName string
+	NodeUUID string
+	TraceID  []byte
+	SpanID   []byte
+	TS       time.Time
+	ReplyTo  string
+	Headers  map[string]any
+	// Signed is true if event was signed, with key that might be unknown to the node
+	Signed bool
+	// Verified is true if signature was verified with sender's key, see Event.Verified
+	Verified bool
+}
+
+// MetaFromEvent extracts metadata from event
+func MetaFromEvent(ev *Event) Meta {
+	return Meta{
+		NodeName: ev.NodeName,
+		NodeUUID: ev.NodeUUID,
+		TraceID:  ev.TraceID,
+		SpanID:   ev.SpanID,
+		TS:       ev.TS,
+		ReplyTo:  ev.ReplyTo,
+		Headers:  ev.Headers,
+		Signed:   len(ev.Signature) > 0,
+		Verified: ev.Verified,
+	}
+}
+
+// Header returns header converted to type T. found is false if header is missing or is of different type
+func Header[T any](m Meta, key string) (v T, found bool) {
+	raw, ok := m.Headers[key]
+	if !ok {
+		return v, false
+	}
+	v, found = raw.(T)
+	return v, found
+}
+
+// SubscriptionError is passed to node's error hook when event could not be decoded or handler failed
+type SubscriptionError struct {
+	Filter string
+	Meta   Meta
+	Err    error
+}
+
+func (e *SubscriptionError) Error() string {
+	return fmt.Sprintf("subscription [%s], event from %s[%s]: %s", e.Filter, e.Meta.NodeName, e.Meta.NodeUUID, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// Subscribe calls fn for each event matching the filter, with body decoded into T using node's decoder.
+// Decode and handler errors are passed to node's ErrorHook
+func Subscribe[T any](n *Node, filter string, fn func(ctx context.Context, meta Meta, v T) error) error {
+	ch, err := n.GetEventsCh(filter)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for ev := range ch {
+			handleTyped(n, filter, &ev, fn)
+		}
+	}()
+	return nil
+}
+
+func handleTyped[T any](n *Node, filter string, ev *Event, fn func(ctx context.Context, meta Meta, v T) error) {
+	meta := MetaFromEvent(ev)
+	var v T
+	if err := n.d.Unmarshal(ev.Body, &v); err != nil {
+		n.reportError(&SubscriptionError{
+			Filter: filter,
+			Meta:   meta,
+			Err:    fmt.Errorf("error decoding body into %T: %w", v, err),
+		})
+		return
+	}
+	if err := fn(context.Background(), meta, v); err != nil {
+		n.reportError(&SubscriptionError{
+			Filter: filter,
+			Meta:   meta,
+			Err:    err,
+		})
+	}
+}
+
+// Publish encodes v with node's encoder and sends it as event body to path
+func Publish[T any](n *Node, path string, v T, traceSpanId ...[]byte) error {
+	ev := n.NewEvent(traceSpanId...)
+	body, err := n.e.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %T: %w", v, err)
+	}
+	ev.Body = body
+	return n.SendEvent(path, ev)
+}
@@ -0,0 +1,67 @@
+package zerosvc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testCake struct {
+	Count int    `cbor:"count"`
+	Type  string `cbor:"type"`
+}
+
+func TestTyped(t *testing.T) {
+	var hookErrors []error
+	node := getTestDummyNode(t, Config{ErrorHook: func(err error) { hookErrors = append(hookErrors, err) }})
+	ev := node.NewEvent()
+	ev.Headers["layers"] = uint64(3)
+	ev.Body, _ = node.e.Marshal(testCake{Count: 2, Type: "chocolate"})
+
+	var got testCake
+	var gotMeta Meta
+	handler := func(ctx context.Context, meta Meta, v testCake) error {
+		got = v
+		gotMeta = meta
+		return nil
+	}
+	handleTyped(node, "cakes/#", &ev, handler)
+	require.Empty(t, hookErrors)
+	assert.Equal(t, testCake{Count: 2, Type: "chocolate"}, got)
+	assert.Equal(t, node.Name, gotMeta.NodeName)
+	assert.Equal(t, ev.SpanID, gotMeta.SpanID)
+	layers, found := Header[uint64](gotMeta, "layers")
+	assert.True(t, found)
+	assert.Equal(t, uint64(3), layers)
+	_, found = Header[string](gotMeta, "layers")
+	assert.False(t, found)
+	_, found = Header[string](gotMeta, "missing")
+	assert.False(t, found)
+
+	t.Run("decode error", func(t *testing.T) {
+		hookErrors = nil
+		bad := node.NewEvent()
+		bad.Body = []byte{0xff, 0x00}
+		handleTyped(node, "cakes/#", &bad, handler)
+		require.Len(t, hookErrors, 1)
+		var subErr *SubscriptionError
+		require.ErrorAs(t, hookErrors[0], &subErr)
+		assert.Equal(t, "cakes/#", subErr.Filter)
+		assert.Equal(t, bad.SpanID, subErr.Meta.SpanID)
+	})
+	t.Run("handler error", func(t *testing.T) {
+		hookErrors = nil
+		errNoCake := errors.New("no cake")
+		handleTyped(node, "cakes/#", &ev, func(ctx context.Context, meta Meta, v testCake) error {
+			return errNoCake
+		})
+		require.Len(t, hookErrors, 1)
+		assert.ErrorIs(t, hookErrors[0], errNoCake)
+	})
+	t.Run("publish", func(t *testing.T) {
+		assert.NoError(t, Publish(node, "cakes/chocolate", testCake{Count: 1}))
+		assert.Error(t, Publish(node, "cakes/chocolate", func() {}))
+	})
+}
@@ -1,6 +1,7 @@
 package zerosvc
 
 import (
+	"crypto/ecdh"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 	"time"
@@ -34,6 +35,32 @@ type Config struct {
 	EventRoot         string
 	HeartbeatInterval time.Duration
 	Logger            *zap.SugaredLogger
+	// LegacyEnvelope makes node send events in unversioned V0 envelope, for talking with old nodes.
+	// Receiving side always accepts every supported version.
+	LegacyEnvelope bool
+	// Compression used for outgoing events. Off by default
+	Compression Compression
+	// CompressionThreshold is minimum serialized event size that gets compressed, DefaultCompressionThreshold if not set
+	CompressionThreshold int
+	// MaxDecompressedSize is limit of received event size after decompression, DefaultMaxDecompressedSize if not set.
+	// Protects from decompression bombs
+	MaxDecompressedSize int
+	// EncryptionKey is X25519 key used to decrypt events sent to this node.
+	// If not set, it is derived from Ed25519 Signer
+	EncryptionKey *ecdh.PrivateKey
+	// ReplayGuard, if set, drops received events that are too old, too new, or were already seen
+	ReplayGuard *ReplayGuard
+	// ErrorHook is called on errors in asynchronous event processing, like failed body decode in Subscribe().
+	// Errors are logged if not set
+	ErrorHook func(err error)
+	// SubscriptionDefaults sets buffer size and overflow policy of GetEventsCh()
+	SubscriptionDefaults SubscriptionOptions
+	// Dedupe, if set, drops events redelivered to the same subscription
+	Dedupe *Deduper
+	// DeadLetterPath, if set, is path (relative to EventRoot) where received events that failed to decode
+	// or were rejected by middleware are republished, with raw payload as body. Original topic, error and receiving node
+	// are in HeaderDeadLetterTopic, HeaderDeadLetterError and HeaderDeadLetterNode headers
+	DeadLetterPath string
 }
 
 type Encoder interface {
@@ -61,8 +88,22 @@ type Event struct {
 	Headers   map[string]any `cbor:"headers" json:"headers"`
 	Signature []byte         `cbor:"-" json:"-"`
 	Body      []byte         `cbor:"b" json:"b"`
-	retain    bool
-	n         *Node
+	// Verified is set on received events if Signature was checked with sender's key from PubkeyRetriever
+	// (or node's own Signer). Signature without Verified means the key was unknown and anyone could have signed it
+	Verified bool `cbor:"-" json:"-"`
+	// Redelivered is set on received events if transport flagged message as possible duplicate
+	Redelivered bool `cbor:"-" json:"-"`
+	// Retained is set on received events that broker delivered from its retained store on subscribe
+	Retained bool `cbor:"-" json:"-"`
+	// Topic is set on received events to the full topic event arrived on
+	Topic  string `cbor:"-" json:"-"`
+	retain bool
+	// Encryption is set if Body and Headers are encrypted
+	Encryption *EncryptionInfo `cbor:"enc,omitempty" json:"enc,omitempty"`
+	// compression overrides node compression settings
+	compression Compression
+	encryptTo   *encryptionTargets
+	n           *Node
 }
 
 type Service struct {
@@ -76,3 +117,15 @@ type ErrSignatureInvalid struct{}
 func (e ErrSignatureInvalid) Error() string {
 	return "signature invalid"
 }
+
+type ErrSharedSubscriptionUnsupported struct{}
+
+func (e ErrSharedSubscriptionUnsupported) Error() string {
+	return "shared subscriptions not supported by broker"
+}
+
+type ErrNotRecipient struct{}
+
+func (e ErrNotRecipient) Error() string {
+	return "no key to decrypt the event"
+}
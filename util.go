@@ -22,6 +22,24 @@ func generatePersistentQueueName(filter string, hashkeys ...string) string {
 	return strings.Join([]string{routingPart, hash[:32]}, "-")
 }
 
+// topicMatch checks whether topic matches MQTT filter with + and # wildcards
+func topicMatch(filter string, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if part != "+" && part != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
 // only alphanum for MQTT standard
 var base64MQTTAlpha = strings.NewReplacer(
 	"+", "1",
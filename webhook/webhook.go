@@ -0,0 +1,394 @@
+// Package webhook forwards events matching topic filters to HTTP endpoints.
+//
+// Every URL gets every event, as POST of JSON {"events": [EventJSON...]} with up to BatchSize events.
+// Failed requests are retried with backoff; events that could not be delivered go to dead-letter path
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zerosvc/go-zerosvc"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+	DefaultBatchInterval  = time.Second
+	DefaultQueueSize      = 1000
+	DefaultTimeout        = time.Second * 10
+	DefaultMaxSkew        = time.Minute * 5
+)
+
+// Request headers
+const (
+	// HeaderSignature is "sha256=<hex>" HMAC of timestamp and body, see Sign
+	HeaderSignature = "X-Zerosvc-Signature"
+	// HeaderTimestamp is unix time request was signed at
+	HeaderTimestamp = "X-Zerosvc-Timestamp"
+)
+
+// Dead-letter event headers, in addition to zerosvc.HeaderDeadLetter*
+const (
+	// HeaderWebhookURL is URL event could not be delivered to
+	HeaderWebhookURL = "_webhook_url"
+	// HeaderWebhookOrigin is name of the node that sent original event
+	HeaderWebhookOrigin = "_webhook_origin"
+)
+
+type Config struct {
+	Node *zerosvc.Node
+	// Name of the sink, used in default dead-letter path
+	Name string
+	// Filters are topic filters relative to event root, like in GetEventsCh.
+	// Event matching more than one of them is delivered once
+	Filters []string
+	// URLs events are POSTed to. Each URL is delivered to independently, so failing one does not delay others
+	URLs []string
+	// Secret used to sign requests; requests are not signed if empty
+	Secret []byte
+	// Headers added to every request, like authorization
+	Headers map[string]string
+	// BatchSize is max number of events per request, 1 if 0
+	BatchSize int
+	// BatchInterval is how long to wait for batch to fill up after its first event, DefaultBatchInterval if 0
+	BatchInterval time.Duration
+	// MaxAttempts of request before events go to dead-letter, DefaultMaxAttempts if 0
+	MaxAttempts int
+	// InitialBackoff before first retry, doubled on each next one; DefaultInitialBackoff if 0
+	InitialBackoff time.Duration
+	// MaxBackoff caps the retry delay, DefaultMaxBackoff if 0
+	MaxBackoff time.Duration
+	// QueueSize is number of events buffered per URL; events over it go straight to dead-letter. DefaultQueueSize if 0
+	QueueSize int
+	// Timeout of single request, DefaultTimeout if 0
+	Timeout time.Duration
+	// DeadLetterPath is where undelivered events are sent, relative to event root. deadletter/webhook/<name> if empty
+	DeadLetterPath string
+	// Client used for requests, http.DefaultClient if nil
+	Client *http.Client
+	Logger *zap.SugaredLogger
+}
+
+type Sink struct {
+	cfg     Config
+	targets []*target
+	dedupe  *zerosvc.Deduper
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	l       *zap.SugaredLogger
+}
+
+// target is queue of events for single URL
+type target struct {
+	url    string
+	events chan zerosvc.Event
+}
+
+// ErrPermanent is returned for responses that retrying won't fix, like 400 or 404; events go to dead-letter right away
+type ErrPermanent struct {
+	Status int
+}
+
+func (e ErrPermanent) Error() string {
+	return fmt.Sprintf("permanent error: HTTP %d", e.Status)
+}
+
+func New(cfg Config) (*Sink, error) {
+	if cfg.Node == nil {
+		return nil, fmt.Errorf("node required")
+	}
+	if len(cfg.Name) == 0 {
+		return nil, fmt.Errorf("name required")
+	}
+	if len(cfg.Filters) == 0 {
+		return nil, fmt.Errorf("at least one filter required")
+	}
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("at least one URL required")
+	}
+	for _, u := range cfg.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL [%s]: %w", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("invalid URL [%s]: scheme must be http or https", u)
+		}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.BatchInterval <= 0 {
+		cfg.BatchInterval = DefaultBatchInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = DefaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if len(cfg.DeadLetterPath) == 0 {
+		cfg.DeadLetterPath = "deadletter/webhook/" + cfg.Name
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	s := &Sink{
+		cfg:    cfg,
+		dedupe: zerosvc.NewDeduper(zerosvc.DedupeConfig{}),
+		stop:   make(chan struct{}),
+		l:      cfg.Logger,
+	}
+	if s.l == nil {
+		s.l = zap.NewNop().Sugar()
+	}
+	for _, u := range cfg.URLs {
+		s.targets = append(s.targets, &target{url: u, events: make(chan zerosvc.Event, cfg.QueueSize)})
+	}
+	return s, nil
+}
+
+// Start subscribes to filters and starts delivering
+func (s *Sink) Start() error {
+	for _, f := range s.cfg.Filters {
+		events, err := s.cfg.Node.GetEventsCh(f)
+		if err != nil {
+			return fmt.Errorf("error subscribing to [%s]: %w", f, err)
+		}
+		go s.dispatch(events)
+	}
+	for _, t := range s.targets {
+		s.wg.Add(1)
+		go s.deliverLoop(t)
+	}
+	return nil
+}
+
+// Stop stops delivery. Events still queued or waiting for retry are sent to dead-letter
+func (s *Sink) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Sink) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Sink) dispatch(events chan zerosvc.Event) {
+	for ev := range events {
+		if s.stopped() {
+			// transport can't unsubscribe, keep draining
+			continue
+		}
+		// every filter has its own subscription, so event matching several arrives once per each.
+		// Deduplicated by topic instead of filter, so the copies collapse
+		if s.dedupe.Seen(ev.Topic, &ev) {
+			continue
+		}
+		for _, t := range s.targets {
+			select {
+			case t.events <- ev:
+			default:
+				s.deadLetter(t, []zerosvc.Event{ev}, fmt.Errorf("queue full"))
+			}
+		}
+	}
+}
+
+func (s *Sink) deliverLoop(t *target) {
+	defer s.wg.Done()
+	for {
+		var batch []zerosvc.Event
+		select {
+		case <-s.stop:
+			s.drain(t)
+			return
+		case ev := <-t.events:
+			batch = append(batch, ev)
+		}
+		timer := time.NewTimer(s.cfg.BatchInterval)
+	fill:
+		for len(batch) < s.cfg.BatchSize {
+			select {
+			case ev := <-t.events:
+				batch = append(batch, ev)
+			case <-timer.C:
+				break fill
+			case <-s.stop:
+				break fill
+			}
+		}
+		timer.Stop()
+		if err := s.deliver(t, batch); err != nil {
+			s.l.Warnf("webhook %s: failed to deliver %d events to %s: %s", s.cfg.Name, len(batch), t.url, err)
+			s.deadLetter(t, batch, err)
+		}
+	}
+}
+
+// drain sends events left in queue to dead-letter
+func (s *Sink) drain(t *target) {
+	for {
+		select {
+		case ev := <-t.events:
+			s.deadLetter(t, []zerosvc.Event{ev}, fmt.Errorf("sink stopped"))
+		default:
+			return
+		}
+	}
+}
+
+// deliver sends batch, retrying with backoff
+func (s *Sink) deliver(t *target, batch []zerosvc.Event) error {
+	out := struct {
+		Events []zerosvc.EventJSON `json:"events"`
+	}{}
+	for i := range batch {
+		out.Events = append(out.Events, zerosvc.NewEventJSON(&batch[i]))
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = s.post(t.url, body)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(ErrPermanent); permanent || attempt >= s.cfg.MaxAttempts {
+			return err
+		}
+		s.l.Debugf("webhook %s: attempt %d to %s failed: %s", s.cfg.Name, attempt, t.url, err)
+		timer := time.NewTimer(s.backoff(attempt))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return fmt.Errorf("sink stopped, last error: %w", err)
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Sink) post(target string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(s.cfg.Secret) > 0 {
+		ts := time.Now().Unix()
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+		req.Header.Set(HeaderSignature, Sign(s.cfg.Secret, ts, body))
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return ErrPermanent{Status: resp.StatusCode}
+	default:
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+}
+
+// backoff returns delay before retrying request that failed given attempt
+func (s *Sink) backoff(attempt int) time.Duration {
+	d := s.cfg.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= s.cfg.MaxBackoff {
+			return s.cfg.MaxBackoff
+		}
+	}
+	return d
+}
+
+// deadLetter re-sends undelivered events to dead-letter path. Events that already are webhook dead-letters
+// are dropped, so sink subscribed to its own dead-letter path can't loop
+func (s *Sink) deadLetter(t *target, batch []zerosvc.Event, reason error) {
+	n := s.cfg.Node
+	for _, ev := range batch {
+		if _, ok := ev.Headers[HeaderWebhookURL]; ok {
+			s.l.Errorf("webhook %s: dropping dead-letter event from [%s]: %s", s.cfg.Name, ev.Topic, reason)
+			continue
+		}
+		dl := n.NewEvent(ev.TraceID)
+		dl.Body = ev.Body
+		dl.ReplyTo = ev.ReplyTo
+		for k, v := range ev.Headers {
+			dl.Headers[k] = v
+		}
+		dl.Headers[zerosvc.HeaderDeadLetterTopic] = ev.Topic
+		dl.Headers[zerosvc.HeaderDeadLetterError] = reason.Error()
+		dl.Headers[zerosvc.HeaderDeadLetterNode] = n.Name
+		dl.Headers[HeaderWebhookURL] = t.url
+		dl.Headers[HeaderWebhookOrigin] = ev.NodeName
+		if err := n.SendEvent(s.cfg.DeadLetterPath, dl); err != nil {
+			s.l.Errorf("webhook %s: error sending event from [%s] to dead-letter: %s", s.cfg.Name, ev.Topic, err)
+		}
+	}
+}
+
+// Sign returns value of HeaderSignature: HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature of received request body, and that it was signed within maxSkew of current time,
+// so captured request can't be replayed later. DefaultMaxSkew if 0
+func Verify(secret []byte, r *http.Request, body []byte, maxSkew time.Duration) bool {
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(r.Header.Get(HeaderSignature)))
+}
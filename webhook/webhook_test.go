@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XANi/goneric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zerosvc/go-zerosvc"
+)
+
+// receiver is test endpoint failing first `fail` requests with `status`
+type receiver struct {
+	fail     int
+	status   int
+	secret   []byte
+	requests int
+	events   []zerosvc.EventJSON
+	batches  []int
+	bad      int
+	sync.Mutex
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.Lock()
+	defer rc.Unlock()
+	rc.requests++
+	if len(rc.secret) > 0 && !Verify(rc.secret, r, body, 0) {
+		rc.bad++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if rc.fail > 0 {
+		rc.fail--
+		w.WriteHeader(rc.status)
+		return
+	}
+	var in struct {
+		Events []zerosvc.EventJSON `json:"events"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.events = append(rc.events, in.Events...)
+	rc.batches = append(rc.batches, len(in.Events))
+}
+
+func (rc *receiver) received() int {
+	rc.Lock()
+	defer rc.Unlock()
+	return len(rc.events)
+}
+
+func testNodes(t *testing.T) (sender *zerosvc.Node, sinkNode *zerosvc.Node, deadLetters chan zerosvc.Event) {
+	broker := zerosvc.NewMemoryBroker()
+	newNode := func(name string) *zerosvc.Node {
+		tr, err := zerosvc.NewTransportMemory(zerosvc.ConfigMemory{Broker: broker})
+		require.NoError(t, err)
+		n, err := zerosvc.NewNode(zerosvc.Config{NodeName: name, Transport: tr, EventRoot: "test"})
+		require.NoError(t, err)
+		return n
+	}
+	sender = newNode("oven")
+	sinkNode = newNode("sink")
+	deadLetters, err := sender.GetEventsCh("deadletter/#")
+	require.NoError(t, err)
+	return sender, sinkNode, deadLetters
+}
+
+func send(t *testing.T, n *zerosvc.Node, count int) {
+	for i := 0; i < count; i++ {
+		ev := n.NewEvent()
+		ev.Headers["i"] = i
+		ev.Body = []byte(`{"temp":180}`)
+		require.NoError(t, n.SendEvent("ovens/temp", ev))
+	}
+}
+
+func TestSinkRetrySigned(t *testing.T) {
+	sender, sinkNode, deadLetters := testNodes(t)
+	rc := &receiver{fail: 2, status: http.StatusServiceUnavailable, secret: []byte("s3cret")}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, err := New(Config{
+		Node:           sinkNode,
+		Name:           "test",
+		Filters:        []string{"ovens/#"},
+		URLs:           []string{srv.URL},
+		Secret:         []byte("s3cret"),
+		InitialBackoff: time.Millisecond * 10,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	send(t, sender, 1)
+	require.Eventually(t, func() bool { return rc.received() == 1 }, time.Second, time.Millisecond*5)
+	rc.Lock()
+	assert.Equal(t, 3, rc.requests)
+	assert.Equal(t, 0, rc.bad)
+	assert.Equal(t, "oven", rc.events[0].Node)
+	assert.Equal(t, "test/ovens/temp", rc.events[0].Topic)
+	assert.JSONEq(t, `{"temp":180}`, string(rc.events[0].Body))
+	assert.NotEmpty(t, rc.events[0].TraceID)
+	rc.Unlock()
+	assert.Empty(t, goneric.ChanToSliceNTimeout(deadLetters, 1, time.Millisecond*50))
+}
+
+func TestSinkBatch(t *testing.T) {
+	sender, sinkNode, _ := testNodes(t)
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, err := New(Config{
+		Node:          sinkNode,
+		Name:          "test",
+		Filters:       []string{"ovens/#"},
+		URLs:          []string{srv.URL},
+		BatchSize:     3,
+		BatchInterval: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	send(t, sender, 4)
+	require.Eventually(t, func() bool { return rc.received() == 4 }, time.Second, time.Millisecond*5)
+	rc.Lock()
+	assert.Equal(t, []int{3, 1}, rc.batches, "last batch should be sent after interval")
+	rc.Unlock()
+}
+
+func TestSinkDeadLetter(t *testing.T) {
+	sender, sinkNode, deadLetters := testNodes(t)
+	permanent := httptest.NewServer(&receiver{fail: 100, status: http.StatusNotFound})
+	defer permanent.Close()
+	flaky := &receiver{fail: 100, status: http.StatusBadGateway}
+	failing := httptest.NewServer(flaky)
+	defer failing.Close()
+	s, err := New(Config{
+		Node:           sinkNode,
+		Name:           "test",
+		Filters:        []string{"ovens/#"},
+		URLs:           []string{permanent.URL, failing.URL},
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	send(t, sender, 1)
+	got := goneric.ChanToSliceNTimeout(deadLetters, 2, time.Second)
+	require.Len(t, got, 2)
+	byURL := map[string]zerosvc.Event{}
+	for _, ev := range got {
+		byURL[ev.Headers[HeaderWebhookURL].(string)] = ev
+	}
+	require.Contains(t, byURL, permanent.URL)
+	require.Contains(t, byURL, failing.URL)
+	assert.Contains(t, byURL[permanent.URL].Headers[zerosvc.HeaderDeadLetterError], "404")
+	assert.Equal(t, "oven", byURL[failing.URL].Headers[HeaderWebhookOrigin])
+	assert.Equal(t, "test/ovens/temp", byURL[failing.URL].Headers[zerosvc.HeaderDeadLetterTopic])
+	assert.Equal(t, "test/deadletter/webhook/test", byURL[failing.URL].Topic)
+	assert.Equal(t, []byte(`{"temp":180}`), byURL[failing.URL].Body)
+	flaky.Lock()
+	assert.Equal(t, 3, flaky.requests, "should give up after MaxAttempts")
+	flaky.Unlock()
+}
+
+func TestNewValidation(t *testing.T) {
+	_, sinkNode, _ := testNodes(t)
+	_, err := New(Config{Node: sinkNode, Name: "x", Filters: []string{"#"}, URLs: []string{"ftp://example.com"}})
+	assert.ErrorContains(t, err, "scheme")
+	_, err = New(Config{Node: sinkNode, Name: "x", URLs: []string{"http://example.com"}})
+	assert.ErrorContains(t, err, "filter")
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"events":[]}`)
+	request := func(ts time.Time) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(HeaderTimestamp, strconv.FormatInt(ts.Unix(), 10))
+		r.Header.Set(HeaderSignature, Sign(secret, ts.Unix(), body))
+		return r
+	}
+	now := time.Now()
+	assert.True(t, Verify(secret, request(now), body, 0))
+	assert.False(t, Verify([]byte("other"), request(now), body, 0))
+	assert.False(t, Verify(secret, request(now), []byte("{}"), 0))
+	assert.False(t, Verify(secret, request(now.Add(-time.Hour)), body, 0), "old request should be rejected")
+	assert.False(t, Verify(secret, request(now.Add(time.Hour)), body, 0), "request from future should be rejected")
+	assert.True(t, Verify(secret, request(now.Add(-time.Hour)), body, time.Hour*2))
+	assert.False(t, Verify(secret, request(now.Add(-time.Minute)), body, time.Second*10))
+}
+
+func TestSinkOverlappingFilters(t *testing.T) {
+	sender, sinkNode, _ := testNodes(t)
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, err := New(Config{
+		Node:    sinkNode,
+		Name:    "test",
+		Filters: []string{"ovens/#", "ovens/temp", "+/temp"},
+		URLs:    []string{srv.URL},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	send(t, sender, 2)
+	require.Eventually(t, func() bool { return rc.received() >= 2 }, time.Second, time.Millisecond*5)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 2, rc.received(), "event matching several filters should be delivered once")
+}